@@ -0,0 +1,376 @@
+﻿package lazypress
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+// BatchItem is a document to convert as part of a batch.
+type BatchItem struct {
+	// Input is the path of an HTML file or the URL of a page.
+	Input string `json:"input"`
+	// Output is the path where the PDF is saved.
+	Output string `json:"output"`
+	// Settings are applied on top of BatchOptions.Settings. See LoadSettings for the available keys.
+	Settings map[string]string `json:"settings,omitempty"`
+}
+
+// BatchOptions configures Batch.
+type BatchOptions struct {
+	// Concurrency is the maximum number of documents converted at the same time. It defaults to 4.
+	Concurrency int
+	// Settings are applied to every item. See LoadSettings for the available keys.
+	Settings map[string]string
+	// Resume skips the items whose PDF already exists, so that an interrupted batch can be started again.
+	Resume bool
+	// Timeout is the maximum time to convert each item. It defaults to 2 minutes.
+	Timeout time.Duration
+}
+
+// BatchResult is the outcome of the conversion of a BatchItem.
+type BatchResult struct {
+	Input    string  `json:"input"`
+	Output   string  `json:"output"`
+	Status   string  `json:"status"`
+	Error    string  `json:"error,omitempty"`
+	Duration float64 `json:"durationSeconds"`
+}
+
+// Statuses of a BatchResult.
+const (
+	BatchSucceeded = "succeeded"
+	BatchFailed    = "failed"
+	BatchSkipped   = "skipped"
+)
+
+// BatchReport summarizes a batch.
+type BatchReport struct {
+	Started   time.Time     `json:"started"`
+	Finished  time.Time     `json:"finished"`
+	Total     int           `json:"total"`
+	Succeeded int           `json:"succeeded"`
+	Failed    int           `json:"failed"`
+	Skipped   int           `json:"skipped"`
+	Results   []BatchResult `json:"results"`
+}
+
+// WriteJSON writes the report as indented JSON.
+func (r *BatchReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// Batch converts the items using a single browser, with at most opts.Concurrency conversions at the same time.
+// Like GenerateWithChrome, it accepts a context.Context to allow for cancellation and customization of the Chrome process.
+// A failing item does not stop the batch: its error is reported in the BatchReport.
+// An error is returned only when the browser could not be started.
+func Batch(ctx context.Context, items []BatchItem, opts BatchOptions) (*BatchReport, error) {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = 4
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Minute
+	}
+	report := &BatchReport{
+		Started: time.Now(),
+		Total:   len(items),
+		Results: make([]BatchResult, len(items)),
+	}
+
+	// every item is converted in a tab of the same browser
+	if c := chromedp.FromContext(ctx); c == nil || c.Browser == nil {
+		metrics.chromeStarts.Inc()
+	}
+	browserCtx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+	if err := chromedp.Run(browserCtx); err != nil {
+		return nil, fmt.Errorf("could not start browser: %v", err)
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < opts.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				report.Results[i] = convertBatchItem(browserCtx, items[i], opts)
+			}
+		}()
+	}
+	for i := range items {
+		select {
+		case jobs <- i:
+		case <-ctx.Done():
+			report.Results[i] = BatchResult{Input: items[i].Input, Output: items[i].Output, Status: BatchFailed, Error: ctx.Err().Error()}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case BatchSucceeded:
+			report.Succeeded++
+		case BatchSkipped:
+			report.Skipped++
+		default:
+			report.Failed++
+		}
+	}
+	report.Finished = time.Now()
+	return report, nil
+}
+
+func convertBatchItem(browserCtx context.Context, item BatchItem, opts BatchOptions) BatchResult {
+	start := time.Now()
+	result := BatchResult{Input: item.Input, Output: item.Output}
+	err := func() error {
+		if opts.Resume {
+			if info, err := os.Stat(item.Output); err == nil && info.Size() > 0 {
+				result.Status = BatchSkipped
+				return nil
+			}
+		}
+		if browserCtx.Err() != nil {
+			return browserCtx.Err()
+		}
+
+		params := make(map[string]string, len(opts.Settings)+len(item.Settings))
+		for k, v := range opts.Settings {
+			params[k] = v
+		}
+		for k, v := range item.Settings {
+			params[k] = v
+		}
+		var p PDF
+		if err := p.LoadSettings(params, io.Discard, nil); err != nil {
+			return err
+		}
+		// the PDF is buffered, to be written atomically
+		p.Stream = false
+		p.Settings.TransferMode = ""
+
+		ctx, cancel := context.WithTimeout(browserCtx, opts.Timeout)
+		defer cancel()
+		if err := p.renderInput(ctx, item.Input); err != nil {
+			return err
+		}
+		return writeFileAtomically(item.Output, p.Content)
+	}()
+	if err != nil {
+		result.Status = BatchFailed
+		result.Error = err.Error()
+	} else if result.Status == "" {
+		result.Status = BatchSucceeded
+	}
+	result.Duration = time.Since(start).Seconds()
+	return result
+}
+
+// renderInput renders a local HTML file or a URL.
+func (p *PDF) renderInput(ctx context.Context, input string) error {
+	if IsURL(input) {
+		return p.renderURL(ctx, input)
+	}
+	if p.Sanitize {
+		html, err := os.ReadFile(input)
+		if err != nil {
+			return err
+		}
+		return p.generateWithChrome(ctx, SanitizeHTML(html))
+	}
+	// load the file from disk, so that relative links to images and stylesheets work
+	abs, err := filepath.Abs(input)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return err
+	}
+	return p.renderURL(ctx, (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String())
+}
+
+// IsURL reports whether s is an http, https or file URL rather than a path.
+func IsURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https" || u.Scheme == "file")
+}
+
+// writeFileAtomically writes the file through a temporary file,
+// so that an interrupted batch never leaves a truncated PDF behind.
+func writeFileAtomically(name string, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(name), ".lazypress*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), name)
+}
+
+// DirectoryBatch returns the items to convert every HTML file in dir into a PDF in outputDir,
+// keeping the same directory structure. If outputDir is empty, PDFs are saved next to the HTML files.
+func DirectoryBatch(dir, outputDir string, recursive bool) ([]BatchItem, error) {
+	var items []BatchItem
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != dir && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		ext := strings.ToLower(filepath.Ext(path))
+		if ext != ".html" && ext != ".htm" {
+			return nil
+		}
+		output := strings.TrimSuffix(path, filepath.Ext(path)) + ".pdf"
+		if outputDir != "" {
+			rel, err := filepath.Rel(dir, output)
+			if err != nil {
+				return err
+			}
+			output = filepath.Join(outputDir, rel)
+		}
+		items = append(items, BatchItem{Input: path, Output: output})
+		return nil
+	})
+	return items, err
+}
+
+// LoadManifest reads the items of a batch from a JSONL or CSV file, depending on its extension.
+//
+// In a JSONL manifest, each line is a BatchItem, e.g.:
+//
+//	{"input": "statements/1.html", "output": "out/1.pdf", "settings": {"landscape": "true"}}
+//
+// A CSV manifest must have a header with the input and output columns.
+// Any other column is used as a setting of the item, and empty cells are ignored.
+//
+// Relative paths are relative to the directory of the manifest, wherever the batch is run from.
+func LoadManifest(name string) ([]BatchItem, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var items []BatchItem
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".jsonl", ".ndjson":
+		items, err = readJSONLManifest(f)
+	case ".csv":
+		items, err = readCSVManifest(f)
+	default:
+		return nil, fmt.Errorf("unsupported manifest %s: use a .jsonl or .csv file", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Dir(name)
+	for i, item := range items {
+		if !IsURL(item.Input) && !filepath.IsAbs(item.Input) {
+			items[i].Input = filepath.Join(dir, item.Input)
+		}
+		if !filepath.IsAbs(item.Output) {
+			items[i].Output = filepath.Join(dir, item.Output)
+		}
+	}
+	return items, nil
+}
+
+func readJSONLManifest(r io.Reader) ([]BatchItem, error) {
+	var items []BatchItem
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+		var item BatchItem
+		if err := json.Unmarshal([]byte(text), &item); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := item.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		items = append(items, item)
+	}
+	return items, scanner.Err()
+}
+
+func readCSVManifest(r io.Reader) ([]BatchItem, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	header := records[0]
+	inputCol, outputCol := -1, -1
+	for i, column := range header {
+		switch strings.ToLower(strings.TrimSpace(column)) {
+		case "input":
+			inputCol = i
+		case "output":
+			outputCol = i
+		}
+	}
+	if inputCol < 0 || outputCol < 0 {
+		return nil, fmt.Errorf("the CSV header must have the input and output columns")
+	}
+	items := make([]BatchItem, 0, len(records)-1)
+	for line, record := range records[1:] {
+		item := BatchItem{Input: record[inputCol], Output: record[outputCol]}
+		for i, value := range record {
+			if i == inputCol || i == outputCol || value == "" {
+				continue
+			}
+			if item.Settings == nil {
+				item.Settings = map[string]string{}
+			}
+			item.Settings[strings.TrimSpace(header[i])] = value
+		}
+		if err := item.validate(); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line+2, err)
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+func (item BatchItem) validate() error {
+	if item.Input == "" {
+		return fmt.Errorf("missing input")
+	}
+	if item.Output == "" {
+		return fmt.Errorf("missing output")
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+﻿package lazypress
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestShouldLoadJSONLManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := writeTestFile(t, filepath.Join(dir, "batch.jsonl"), `{"input": "a.html", "output": "a.pdf"}
+
+{"input": "https://example.com", "output": "b.pdf", "settings": {"landscape": "true"}}
+`)
+	items, err := LoadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchItem{
+		{Input: filepath.Join(dir, "a.html"), Output: filepath.Join(dir, "a.pdf")},
+		{Input: "https://example.com", Output: filepath.Join(dir, "b.pdf"), Settings: map[string]string{"landscape": "true"}},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected items to be %v, got %v", expected, items)
+	}
+}
+
+func TestShouldLoadCSVManifest(t *testing.T) {
+	dir := t.TempDir()
+	manifest := writeTestFile(t, filepath.Join(dir, "jobs", "batch.csv"), `input,output,landscape,scale
+a.html,../pdfs/a.pdf,true,
+`+filepath.Join(dir, "b.html")+`,b.pdf,,1.5
+`)
+	items, err := LoadManifest(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchItem{
+		{Input: filepath.Join(dir, "jobs", "a.html"), Output: filepath.Join(dir, "pdfs", "a.pdf"), Settings: map[string]string{"landscape": "true"}},
+		{Input: filepath.Join(dir, "b.html"), Output: filepath.Join(dir, "jobs", "b.pdf"), Settings: map[string]string{"scale": "1.5"}},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected items to be %v, got %v", expected, items)
+	}
+}
+
+func TestShouldRejectManifestWithoutOutput(t *testing.T) {
+	manifest := writeTestFile(t, filepath.Join(t.TempDir(), "batch.jsonl"), `{"input": "a.html"}`)
+	if _, err := LoadManifest(manifest); err == nil {
+		t.Error("Expected an error when an item has no output")
+	}
+}
+
+func TestShouldListDirectoryBatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "a.html"), "<html></html>")
+	writeTestFile(t, filepath.Join(dir, "notes.txt"), "notes")
+	writeTestFile(t, filepath.Join(dir, "sub", "b.htm"), "<html></html>")
+
+	items, err := DirectoryBatch(dir, "out", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []BatchItem{
+		{Input: filepath.Join(dir, "a.html"), Output: filepath.Join("out", "a.pdf")},
+		{Input: filepath.Join(dir, "sub", "b.htm"), Output: filepath.Join("out", "sub", "b.pdf")},
+	}
+	if !reflect.DeepEqual(items, expected) {
+		t.Errorf("Expected items to be %v, got %v", expected, items)
+	}
+
+	items, err = DirectoryBatch(dir, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 || items[0].Output != filepath.Join(dir, "a.pdf") {
+		t.Errorf("Expected only the top level file with the PDF next to it, got %v", items)
+	}
+}
+
+func TestShouldSkipExistingOutputsWhenResuming(t *testing.T) {
+	output := writeTestFile(t, filepath.Join(t.TempDir(), "a.pdf"), "%PDF-1.4")
+	result := convertBatchItem(context.Background(), BatchItem{Input: "a.html", Output: output}, BatchOptions{Resume: true})
+	if result.Status != BatchSkipped {
+		t.Errorf("Expected item to be skipped, got %+v", result)
+	}
+}
+
+func TestShouldReportFailedItems(t *testing.T) {
+	dir := t.TempDir()
+	item := BatchItem{Input: filepath.Join(dir, "missing.html"), Output: filepath.Join(dir, "missing.pdf")}
+	result := convertBatchItem(context.Background(), item, BatchOptions{})
+	if result.Status != BatchFailed || result.Error == "" {
+		t.Errorf("Expected item to fail, got %+v", result)
+	}
+	if _, err := os.Stat(item.Output); err == nil {
+		t.Error("Expected no PDF to be written for a failed item")
+	}
+}
+
+func TestShouldWriteFileAtomically(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "out", "a.pdf")
+	if err := writeFileAtomically(name, []byte("%PDF-1.4")); err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "%PDF-1.4" {
+		t.Errorf("Expected file content to be written, got %q", content)
+	}
+	entries, _ := os.ReadDir(filepath.Dir(name))
+	if len(entries) != 1 {
+		t.Errorf("Expected no temporary file to be left, got %v", entries)
+	}
+}
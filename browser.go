@@ -2,77 +2,286 @@
 
 import (
 	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"sync"
+	"time"
 
+	"github.com/chromedp/cdproto/cdp"
 	"github.com/chromedp/cdproto/emulation"
+	cdpio "github.com/chromedp/cdproto/io"
 	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // GenerateWithChrome creates a PDF from the given HTML using Google Chrome.
 // It accepts a context.Context to allow for cancellation and customization of the Chrome process.
 // It accepts a []byte of HTML to be loaded into the browser.
 // It returns a pointer to a PDF struct.
+// If the PDF could not be generated, the error is logged and Content is left empty.
+// When Stream is set, the PDF is written to the Exporter while Chrome prints it, and Content is left empty too.
+// The request ID carried by ctx (see WithRequestID) is used for RequestID when it is not set.
+// The render is bounded by Limits, e.g. it fails when the page does not load within Limits.LoadTimeout.
 func (p *PDF) GenerateWithChrome(ctx context.Context, html []byte) *PDF {
-	var wg sync.WaitGroup
+	if err := p.generateWithChrome(ctx, html); err != nil {
+		p.logger().Error("could not generate PDF", "error", err)
+	}
+	return p
+}
 
-	chromeCtx, cancel := chromedp.NewContext(ctx)
-	defer cancel()
+// Render is like GenerateWithChrome, but it returns the error instead of logging it.
+// When the render goes over one of the Limits, the error wraps ErrLoadTimeout, ErrPrintTimeout,
+// ErrRenderTimeout, ErrHTMLTooLarge, ErrTooManyPages or ErrPDFTooLarge.
+func (p *PDF) Render(ctx context.Context, html []byte) error {
+	return p.generateWithChrome(ctx, html)
+}
 
-	// add a listener for when the page is fully loaded
-	// this allows us to give the page time to render the images as well
-	wg.Add(1)
-	chromedp.ListenTarget(chromeCtx, func(ev interface{}) {
-		switch ev.(type) {
-		case *page.EventLoadEventFired:
-			go func() {
-				defer wg.Done()
-
-				// create the pdf
-				if err := chromedp.Run(chromeCtx, chromedp.ActionFunc(func(ctx context.Context) error {
-					buf, _, err := p.Settings.Do(ctx)
-					if err != nil {
-						return err
-					}
-					p.Content = buf
-					log.Println("PDF content created")
-					return nil
-				})); err != nil {
-					log.Println(err)
-				}
+// GenerateFromURL creates a PDF from the page at the given URL using Google Chrome.
+// It behaves like GenerateWithChrome, but Chrome navigates to the URL instead of loading HTML from memory,
+// so relative links to images and stylesheets are resolved against the URL.
+func (p *PDF) GenerateFromURL(ctx context.Context, url string) *PDF {
+	if err := p.RenderURL(ctx, url); err != nil {
+		p.logger().Error("could not generate PDF", "error", err, "url", url)
+	}
+	return p
+}
 
-			}()
+// RenderURL is like GenerateFromURL, but it returns the error instead of logging it.
+func (p *PDF) RenderURL(ctx context.Context, url string) error {
+	ctx, span := startSpan(ctx, "lazypress.render", attribute.String("lazypress.url", url))
+	err := p.renderURL(ctx, url)
+	endSpan(span, err)
+	return err
+}
 
-		}
-	})
+func (p *PDF) generateWithChrome(ctx context.Context, html []byte) (err error) {
+	ctx, span := startSpan(ctx, "lazypress.render", attribute.Int("lazypress.html_bytes", len(html)))
+	defer func() {
+		endSpan(span, err)
+	}()
+	p.ExpandTemplates(html)
+	if p.Limits.MaxHTMLBytes > 0 && len(html) > p.Limits.MaxHTMLBytes {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", ErrHTMLTooLarge, len(html), p.Limits.MaxHTMLBytes)
+	}
 
 	// save the HTML content to a temporary file
+	_, fileSpan := startSpan(ctx, "lazypress.write_temp_file")
 	htmlFile, err := ioutil.TempFile("", "lazypress*.html")
 	if err != nil {
-		log.Fatalln(err)
+		err = fmt.Errorf("could not create temporary file: %v", err)
+		endSpan(fileSpan, err)
+		return err
 	}
 	defer os.Remove(htmlFile.Name())
 	defer htmlFile.Close()
 	if _, err := htmlFile.Write(html); err != nil {
-		log.Fatalln(err)
+		err = fmt.Errorf("could not write temporary file: %v", err)
+		endSpan(fileSpan, err)
+		return err
 	}
+	fileSpan.End()
 
-	// start browser and load html
-	if err := chromedp.Run(chromeCtx, loadHTMLInBrowser(html, htmlFile.Name())); err != nil {
-		log.Fatalln(err)
+	return p.renderURL(ctx, fmt.Sprintf("file://%s", htmlFile.Name()))
+}
+
+// renderURL loads the URL in a new Chrome tab and prints it once the page is loaded.
+// Each phase is bounded by its timeout in p.Limits, and the printed PDF is checked against the other limits.
+func (p *PDF) renderURL(ctx context.Context, url string) error {
+	if p.RequestID == "" {
+		p.RequestID = RequestIDFromContext(ctx)
 	}
+	p.ExpandTemplates(nil)
+	logger := p.logger()
+	renderStart := time.Now()
+	defer func() {
+		p.Timings.Render = time.Since(renderStart)
+	}()
+	ctx, cancelRender := withTimeout(ctx, p.Limits.Timeout, ErrRenderTimeout)
+	defer cancelRender()
 
-	wg.Wait()
+	// a new Chrome process is started, unless the context already holds a browser
+	if c := chromedp.FromContext(ctx); c == nil || c.Browser == nil {
+		metrics.chromeStarts.Inc()
+	}
+	chromeCtx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+	// the tab is opened first, as it is closed along with the context of the first Run
+	if err := chromedp.Run(chromeCtx); err != nil {
+		return limitError(chromeCtx, "could not start browser", err)
+	}
 
-	return p
+	// add a listener for when the page is fully loaded
+	// this allows us to give the page time to render the images as well
+	loaded := make(chan struct{})
+	var once sync.Once
+	chromedp.ListenTarget(chromeCtx, func(ev interface{}) {
+		logBrowserEvent(logger, ev)
+		if _, ok := ev.(*page.EventLoadEventFired); ok {
+			once.Do(func() { close(loaded) })
+		}
+	})
 
+	// start browser and load the page
+	loadStart := time.Now()
+	loadCtx, cancelLoad := withTimeout(chromeCtx, p.Limits.LoadTimeout, ErrLoadTimeout)
+	defer cancelLoad()
+	_, navigateSpan := startSpan(ctx, "lazypress.navigate")
+	err := chromedp.Run(loadCtx, loadURLInBrowser(url))
+	endSpan(navigateSpan, err)
+	if err != nil {
+		return limitError(loadCtx, "could not load page in browser", err)
+	}
+	_, loadSpan := startSpan(ctx, "lazypress.wait_load_event")
+	select {
+	case <-loaded:
+		loadSpan.End()
+	case <-loadCtx.Done():
+		err := limitError(loadCtx, "could not load page in browser", nil)
+		endSpan(loadSpan, err)
+		return err
+	}
+	if p.FontFaces != "" {
+		_, fontsSpan := startSpan(ctx, "lazypress.load_fonts")
+		err := p.addFontFaces(loadCtx)
+		endSpan(fontsSpan, err)
+		if err != nil {
+			return limitError(loadCtx, "could not load page in browser", err)
+		}
+	}
+	metrics.observePhase(phaseLoad, loadStart)
+	p.Timings.Load = time.Since(loadStart)
+
+	// create the pdf
+	printCtx, cancelPrint := withTimeout(chromeCtx, p.Limits.PrintTimeout, ErrPrintTimeout)
+	defer cancelPrint()
+	printStart := time.Now()
+	if p.TOC.Enabled {
+		_, tocSpan := startSpan(ctx, "lazypress.table_of_contents")
+		err := p.insertTableOfContents(printCtx)
+		endSpan(tocSpan, err)
+		if err != nil {
+			return limitError(printCtx, "could not create table of contents", err)
+		}
+	}
+	_, printSpan := startSpan(ctx, "lazypress.print_to_pdf")
+	if p.streaming() {
+		written, err := p.streamPDF(printCtx)
+		endSpan(printSpan, err)
+		if err != nil {
+			if errors.Is(err, ErrPDFTooLarge) {
+				return err
+			}
+			return limitError(printCtx, "could not create PDF", err)
+		}
+		metrics.observePhase(phasePrint, printStart)
+		p.Timings.Print = time.Since(printStart)
+		metrics.pdfSize.Observe(float64(written))
+		logger.Info("PDF content streamed", "bytes", written)
+		return nil
+	}
+	settings := p.Settings
+	settings.TransferMode = ""
+	if p.PageTemplates.enabled() {
+		// the headers and footers are stamped afterwards
+		settings.DisplayHeaderFooter = false
+	}
+	var buf []byte
+	err = chromedp.Run(printCtx, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		buf, _, err = settings.Do(ctx)
+		return err
+	}))
+	if err == nil && p.PageTemplates.enabled() {
+		buf, err = p.stampPageTemplates(printCtx, buf)
+	}
+	if err == nil && len(p.Properties) > 0 {
+		buf, err = setProperties(buf, p.Properties)
+	}
+	endSpan(printSpan, err)
+	if err != nil {
+		return limitError(printCtx, "could not create PDF", err)
+	}
+	metrics.observePhase(phasePrint, printStart)
+	p.Timings.Print = time.Since(printStart)
+
+	_, postSpan := startSpan(ctx, "lazypress.post_process")
+	postSpan.SetAttributes(attribute.Int("lazypress.pdf_bytes", len(buf)))
+	if err := p.Limits.checkPDF(buf); err != nil {
+		endSpan(postSpan, err)
+		return err
+	}
+	metrics.observePDF(buf)
+	p.Content = buf
+	postSpan.End()
+	logger.Info("PDF content created", "bytes", len(buf))
+	return nil
+}
+
+// streamChunkSize is the size of the chunks read from Chrome when streaming a PDF.
+const streamChunkSize = 256 * 1024
+
+// streaming reports whether the PDF is streamed to the Exporter instead of being kept in Content.
+// PDFs with PageTemplates or Properties are not streamed, as they are changed once printed.
+func (p *PDF) streaming() bool {
+	return (p.Stream || p.Settings.TransferMode == page.PrintToPDFTransferModeReturnAsStream) && !p.PageTemplates.enabled() && len(p.Properties) == 0
+}
+
+// streamPDF prints the PDF with Chrome's stream transfer mode and writes it to the Exporter as it is read.
+// The next chunk is read from Chrome only once the previous one is written,
+// so a slow exporter slows down the reads instead of piling up the document in memory.
+func (p *PDF) streamPDF(ctx context.Context) (int64, error) {
+	if err := p.OpenExport(); err != nil {
+		return 0, err
+	}
+	if p.Exporter == nil {
+		return 0, fmt.Errorf("no exporter set")
+	}
+	settings := p.Settings
+	settings.TransferMode = page.PrintToPDFTransferModeReturnAsStream
+	var written int64
+	// the commands run through chromedp.Run, which gives them the executor of the tab
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		_, handle, err := settings.Do(ctx)
+		if err != nil {
+			return err
+		}
+		defer cdpio.Close(handle).Do(ctx)
+
+		for {
+			// IO.read is executed directly, as ReadParams.Do drops whether the data is base64 encoded
+			var res cdpio.ReadReturns
+			if err := cdp.Execute(ctx, cdpio.CommandRead, cdpio.Read(handle).WithSize(streamChunkSize), &res); err != nil {
+				return fmt.Errorf("could not read PDF stream: %v", err)
+			}
+			chunk := []byte(res.Data)
+			if res.Base64encoded {
+				if chunk, err = base64.StdEncoding.DecodeString(res.Data); err != nil {
+					return fmt.Errorf("could not decode PDF stream: %v", err)
+				}
+			}
+			if max := p.Limits.MaxPDFBytes; max > 0 && written+int64(len(chunk)) > max {
+				return fmt.Errorf("%w: the maximum is %d bytes", ErrPDFTooLarge, max)
+			}
+			if len(chunk) > 0 {
+				n, err := p.Exporter.Write(chunk)
+				written += int64(n)
+				p.streamed = true
+				if err != nil {
+					return fmt.Errorf("could not export PDF: %v", err)
+				}
+			}
+			if res.EOF {
+				return nil
+			}
+		}
+	}))
+	return written, err
 }
 
-func loadHTMLInBrowser(html []byte, fileName string) chromedp.Tasks {
+func loadURLInBrowser(url string) chromedp.Tasks {
 	return chromedp.Tasks{
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			if err := emulation.SetScriptExecutionDisabled(true).Do(ctx); err != nil {
@@ -80,7 +289,7 @@ func loadHTMLInBrowser(html []byte, fileName string) chromedp.Tasks {
 			}
 			return nil
 		}),
-		chromedp.Navigate(fmt.Sprintf("file://%s", fileName)),
+		chromedp.Navigate(url),
 		chromedp.ActionFunc(func(ctx context.Context) error {
 			if err := emulation.SetDeviceMetricsOverride(1920, 1080, 0, false).Do(ctx); err != nil {
 				return err
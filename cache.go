@@ -0,0 +1,344 @@
+﻿package lazypress
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+)
+
+// RenderCache stores the rendered PDFs by key (see RenderKey), so that identical conversions do not run Chrome again.
+// Implementations must be safe for concurrent use.
+type RenderCache interface {
+	// Get returns the PDF stored under the key, if it is there and has not expired.
+	Get(key string) ([]byte, bool)
+	// Set stores the PDF under the key.
+	Set(key string, pdf []byte)
+}
+
+// Results of a cache lookup, as reported by the lazypress_cache_requests_total metric and the X-Cache header.
+const (
+	cacheHit    = "hit"
+	cacheMiss   = "miss"
+	cacheBypass = "bypass"
+)
+
+// renderKeyVersion is part of the keys, so that changing how they are computed does not return stale PDFs.
+const renderKeyVersion = "lazypress-render-v1"
+
+// RenderKey returns the key of a conversion: the SHA-256 of the HTML, the assets it is rendered with and the print settings.
+// Since Chrome renders the same input with the same settings the same way, the key identifies the PDF.
+func RenderKey(html []byte, assets [][]byte, settings page.PrintToPDFParams) string {
+	// the transfer mode does not change the PDF
+	settings.TransferMode = ""
+	normalized, err := json.Marshal(settings)
+	if err != nil {
+		// e.g. a NaN, which LoadSettings rejects, but the key must still depend on the settings
+		normalized = fmt.Appendf(nil, "%#v", settings)
+	}
+	h := sha256.New()
+	for _, part := range append([][]byte{[]byte(renderKeyVersion), normalized, html}, assets...) {
+		// prefix each part with its length, so that moving bytes from a part to the next changes the key
+		var size [8]byte
+		for i, n := 0, uint64(len(part)); i < 8; i++ {
+			size[i] = byte(n >> (8 * i))
+		}
+		h.Write(size[:])
+		h.Write(part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// renderAssets returns what the PDF is rendered with besides the HTML and the print settings, for its RenderKey.
+func (p *PDF) renderAssets() [][]byte {
+	var assets [][]byte
+	if p.PageTemplates.enabled() {
+		templates, _ := json.Marshal(p.PageTemplates)
+		assets = append(assets, templates)
+	}
+	if p.TOC.Enabled {
+		toc, _ := json.Marshal(p.TOC)
+		assets = append(assets, toc)
+	}
+	if len(p.Properties) > 0 {
+		properties, _ := json.Marshal(p.Properties)
+		assets = append(assets, properties)
+	}
+	if p.FontFaces != "" {
+		assets = append(assets, []byte(p.FontFaces))
+	}
+	return assets
+}
+
+// newRenderCache returns the configured cache, or nil if it is disabled.
+func newRenderCache(cfg CacheConfig) RenderCache {
+	switch cfg.Backend {
+	case "memory":
+		return NewMemoryCache(cfg.MaxBytes, cfg.TTL)
+	case "disk":
+		dir := cfg.Dir
+		if dir == "" {
+			cacheDir, err := os.UserCacheDir()
+			if err != nil {
+				cacheDir = os.TempDir()
+			}
+			dir = filepath.Join(cacheDir, "lazypress")
+		}
+		cache, err := NewDiskCache(dir, cfg.MaxBytes, cfg.TTL)
+		if err != nil {
+			getLogger().Warn("could not create the cache directory, the cache is disabled", "error", err)
+			return nil
+		}
+		return cache
+	}
+	return nil
+}
+
+// cacheLookup is the result of looking up a conversion in the render cache.
+type cacheLookup struct {
+	// key is empty when the cache is disabled
+	key    string
+	status string
+	// store reports whether to cache the PDF once rendered
+	store bool
+}
+
+// lookupCache looks up the conversion in the render cache, and sets the content of the PDF on a hit.
+// The cache is bypassed with cache=false, or the no-cache and no-store directives of the Cache-Control request header.
+func (s *Server) lookupCache(r *http.Request, params map[string]string, p *PDF, html []byte) cacheLookup {
+	if s.cache == nil {
+		return cacheLookup{}
+	}
+	lookup := cacheLookup{key: RenderKey(html, p.renderAssets(), p.Settings), status: cacheBypass}
+	useCache, err := strconv.ParseBool(params["cache"])
+	if err != nil {
+		useCache = true
+	}
+	directives := strings.ToLower(r.Header.Get("Cache-Control"))
+	noStore := strings.Contains(directives, "no-store")
+	lookup.store = useCache && !noStore
+	if useCache && !noStore && !strings.Contains(directives, "no-cache") {
+		if pdf, ok := s.cache.Get(lookup.key); ok {
+			p.Content = pdf
+			lookup.status = cacheHit
+		} else {
+			lookup.status = cacheMiss
+		}
+	}
+	metrics.cacheRequests.WithLabelValues(lookup.status).Inc()
+	return lookup
+}
+
+// ifNoneMatch reports whether the If-None-Match header of the request matches the ETag.
+// The * wildcard only matches a PDF that exists, i.e. that was rendered.
+func ifNoneMatch(r *http.Request, etag string, exists bool) bool {
+	for _, candidate := range strings.Split(r.Header.Get("If-None-Match"), ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || (candidate == "*" && exists) {
+			return true
+		}
+	}
+	return false
+}
+
+// MemoryCache is a RenderCache keeping the PDFs in memory, evicting the least recently used ones beyond MaxBytes.
+type MemoryCache struct {
+	maxBytes int64
+	ttl      time.Duration
+
+	mu    sync.Mutex
+	size  int64
+	order *list.List // of *memoryEntry, the most recently used first
+	items map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	pdf     []byte
+	expires time.Time
+}
+
+// NewMemoryCache returns a MemoryCache holding at most maxBytes of PDFs, each for ttl.
+// A ttl of 0 keeps the PDFs until they are evicted.
+func NewMemoryCache(maxBytes int64, ttl time.Duration) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		ttl:      ttl,
+		order:    list.New(),
+		items:    map[string]*list.Element{},
+	}
+}
+
+// Get returns the PDF stored under the key.
+func (c *MemoryCache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := el.Value.(*memoryEntry)
+	if !entry.expires.IsZero() && time.Now().After(entry.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return entry.pdf, true
+}
+
+// Set stores the PDF under the key. PDFs larger than the cache are not stored.
+func (c *MemoryCache) Set(key string, pdf []byte) {
+	if int64(len(pdf)) > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.remove(el)
+	}
+	entry := &memoryEntry{key: key, pdf: pdf}
+	if c.ttl > 0 {
+		entry.expires = time.Now().Add(c.ttl)
+	}
+	c.items[key] = c.order.PushFront(entry)
+	c.size += int64(len(pdf))
+	for c.size > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *MemoryCache) remove(el *list.Element) {
+	entry := c.order.Remove(el).(*memoryEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.pdf))
+}
+
+// DiskCache is a RenderCache keeping the PDFs as files in a directory,
+// evicting the least recently used ones beyond MaxBytes.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+	ttl      time.Duration
+
+	mu sync.Mutex
+	// accessed is when the PDFs were last read, for the evictions.
+	// The PDFs not read since the cache was created count as read when they were stored.
+	accessed map[string]time.Time
+}
+
+// NewDiskCache returns a DiskCache storing at most maxBytes of PDFs in dir, each for ttl.
+// A ttl of 0 keeps the PDFs until they are evicted.
+func NewDiskCache(dir string, maxBytes int64, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{dir: dir, maxBytes: maxBytes, ttl: ttl, accessed: map[string]time.Time{}}, nil
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".pdf")
+}
+
+// Get returns the PDF stored under the key.
+func (c *DiskCache) Get(key string) ([]byte, bool) {
+	name := c.path(key)
+	info, err := os.Stat(name)
+	if err != nil {
+		return nil, false
+	}
+	// the modification time is when the PDF was stored
+	if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+		os.Remove(name)
+		c.mu.Lock()
+		delete(c.accessed, key)
+		c.mu.Unlock()
+		return nil, false
+	}
+	pdf, err := os.ReadFile(name)
+	if err != nil {
+		return nil, false
+	}
+	c.mu.Lock()
+	c.accessed[key] = time.Now()
+	c.mu.Unlock()
+	return pdf, true
+}
+
+// Set stores the PDF under the key. PDFs larger than the cache are not stored.
+func (c *DiskCache) Set(key string, pdf []byte) {
+	if int64(len(pdf)) > c.maxBytes {
+		return
+	}
+	if err := writeFileAtomically(c.path(key), pdf); err != nil {
+		getLogger().Warn("could not cache PDF", "error", err)
+		return
+	}
+	c.evict()
+}
+
+// evict removes the expired PDFs, then the least recently used ones until the cache fits in maxBytes.
+func (c *DiskCache) evict() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	type cached struct {
+		key      string
+		name     string
+		size     int64
+		accessed time.Time
+	}
+	var files []cached
+	var size int64
+	err := filepath.WalkDir(c.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || filepath.Ext(name) != ".pdf" {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		key := strings.TrimSuffix(filepath.Base(name), ".pdf")
+		if c.ttl > 0 && time.Since(info.ModTime()) > c.ttl {
+			os.Remove(name)
+			delete(c.accessed, key)
+			return nil
+		}
+		accessed, ok := c.accessed[key]
+		if !ok {
+			accessed = info.ModTime()
+		}
+		files = append(files, cached{key: key, name: name, size: info.Size(), accessed: accessed})
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		getLogger().Warn("could not evict cached PDFs", "error", err)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].accessed.Before(files[j].accessed)
+	})
+	for _, file := range files {
+		if size <= c.maxBytes {
+			break
+		}
+		if err := os.Remove(file.name); err == nil {
+			size -= file.size
+			delete(c.accessed, file.key)
+		}
+	}
+}
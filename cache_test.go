@@ -0,0 +1,180 @@
+﻿package lazypress
+
+import (
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+)
+
+func TestShouldComputeRenderKeysFromHTMLAndSettings(t *testing.T) {
+	html := []byte("<html><body>Hello World</body></html>")
+	key := RenderKey(html, nil, page.PrintToPDFParams{Landscape: true})
+	if key != RenderKey(html, nil, page.PrintToPDFParams{Landscape: true, TransferMode: page.PrintToPDFTransferModeReturnAsStream}) {
+		t.Error("Expected the transfer mode not to change the key")
+	}
+	for name, other := range map[string]string{
+		"html":     RenderKey([]byte("<html></html>"), nil, page.PrintToPDFParams{Landscape: true}),
+		"settings": RenderKey(html, nil, page.PrintToPDFParams{}),
+		"assets":   RenderKey(html, [][]byte{[]byte("body { color: red }")}, page.PrintToPDFParams{Landscape: true}),
+	} {
+		if other == key {
+			t.Errorf("Expected the %s to change the key", name)
+		}
+	}
+	// settings that cannot be encoded in JSON still change the key
+	if RenderKey(html, nil, page.PrintToPDFParams{Scale: math.NaN()}) == RenderKey(html, nil, page.PrintToPDFParams{Scale: math.NaN(), Landscape: true}) {
+		t.Error("Expected the settings to change the key when they cannot be encoded")
+	}
+}
+
+func TestShouldEvictLeastRecentlyUsedPDFsFromMemory(t *testing.T) {
+	c := NewMemoryCache(10, 0)
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	c.Get("a")
+	c.Set("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+	c.Set("big", make([]byte, 11))
+	if _, ok := c.Get("big"); ok {
+		t.Error("Expected a PDF larger than the cache not to be cached")
+	}
+}
+
+func TestShouldExpireCachedPDFs(t *testing.T) {
+	disk, err := NewDiskCache(t.TempDir(), 100, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]RenderCache{"memory": NewMemoryCache(100, time.Millisecond), "disk": disk} {
+		c.Set("a", []byte("%PDF"))
+		time.Sleep(5 * time.Millisecond)
+		if _, ok := c.Get("a"); ok {
+			t.Errorf("Expected the PDF to expire from the %s cache", name)
+		}
+	}
+}
+
+func TestShouldEvictLeastRecentlyUsedPDFsFromDisk(t *testing.T) {
+	c, err := NewDiskCache(t.TempDir(), 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set("a", []byte("aaaa"))
+	c.Set("b", []byte("bbbb"))
+	if pdf, ok := c.Get("a"); !ok || string(pdf) != "aaaa" {
+		t.Errorf("Expected a to be cached, got %q", pdf)
+	}
+	c.Set("c", []byte("cccc"))
+	if _, ok := c.Get("b"); ok {
+		t.Error("Expected b to be evicted")
+	}
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Expected %s to be cached", key)
+		}
+	}
+}
+
+func newCachingServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Cache.Backend = "memory"
+	s := newServer(cfg)
+	var p PDF
+	if err := p.LoadSettings(map[string]string{"landscape": "true", "output": "download"}, io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	key := RenderKey([]byte("<html><body>Hello World</body></html>"), nil, p.Settings)
+	s.cache.Set(key, []byte("%PDF-1.4 cached"))
+	return s, key
+}
+
+func TestShouldServeCachedPDFs(t *testing.T) {
+	s, key := newCachingServer(t)
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?landscape=true", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusOK || w.Body.String() != "%PDF-1.4 cached" {
+		t.Errorf("Expected the cached PDF, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"`+key+`"` || w.Header().Get("X-Cache") != "HIT" {
+		t.Errorf("Expected the ETag and X-Cache headers, got %v", w.Header())
+	}
+	if w.Header().Get("Cache-Control") != "private, max-age=3600" {
+		t.Errorf("Expected Cache-Control to follow the TTL, got %q", w.Header().Get("Cache-Control"))
+	}
+
+	r := newConvertRequest(t, "/convert?landscape=true", "<html><body>Hello World</body></html>")
+	r.Header.Set("If-None-Match", `"`+key+`"`)
+	w = httptest.NewRecorder()
+	s.handleConvert(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code to be 412, got %d", w.Code)
+	}
+
+	// without Chrome, the conversions that are not answered from the cache fail
+	for _, tc := range []struct{ target, html, ifNoneMatch string }{
+		{"/convert?landscape=true&cache=false", "<html><body>Hello World</body></html>", `"` + key + `"`},
+		{"/convert?landscape=true", "<html><body>Not rendered yet</body></html>", "*"},
+	} {
+		r := newConvertRequest(t, tc.target, tc.html)
+		r.Header.Set("If-None-Match", tc.ifNoneMatch)
+		w := httptest.NewRecorder()
+		s.handleConvert(w, r)
+		if w.Code == http.StatusPreconditionFailed || w.Code == http.StatusNotModified {
+			t.Errorf("Expected %s with If-None-Match: %s not to fail its precondition, got %d", tc.target, tc.ifNoneMatch, w.Code)
+		}
+	}
+	r = newConvertRequest(t, "/convert?landscape=true&output=Download", "<html><body>Hello World</body></html>")
+	r.Header.Set("If-None-Match", "*")
+	w = httptest.NewRecorder()
+	s.handleConvert(w, r)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("Expected status code to be 412 for a cached PDF, ignoring the case of the output, got %d", w.Code)
+	}
+
+	// without a default output, the PDF is downloaded too
+	s.config.Output.Default = ""
+	w = httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?landscape=true", "<html><body>Hello World</body></html>"))
+	if w.Header().Get("ETag") != `"`+key+`"` {
+		t.Errorf("Expected the default output to get an ETag, got %v", w.Header())
+	}
+}
+
+func TestShouldBypassTheCache(t *testing.T) {
+	s, _ := newCachingServer(t)
+	var p PDF
+	p.LoadSettings(map[string]string{"landscape": "true", "output": "download"}, io.Discard, nil)
+	html := []byte("<html><body>Hello World</body></html>")
+
+	for _, tc := range []struct {
+		target, cacheControl string
+		status               string
+		store                bool
+	}{
+		{"/convert", "", cacheHit, true},
+		{"/convert?cache=false", "", cacheBypass, false},
+		{"/convert", "no-cache", cacheBypass, true},
+		{"/convert", "no-store", cacheBypass, false},
+	} {
+		r := httptest.NewRequest("POST", tc.target, nil)
+		r.Header.Set("Cache-Control", tc.cacheControl)
+		params, _ := s.requestParams(r)
+		lookup := s.lookupCache(r, params, &PDF{Settings: p.Settings}, html)
+		if lookup.status != tc.status || lookup.store != tc.store {
+			t.Errorf("Expected %s (Cache-Control: %s) to be a %s storing %v, got %+v", tc.target, tc.cacheControl, tc.status, tc.store, lookup)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/alexferrari88/lazypress"
+)
+
+func batch(args []string) int {
+	var opts convertOptions
+	var outputDir, reportPath string
+	var concurrency int
+	var resume bool
+	fs := flag.NewFlagSet("batch", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lazypress batch [flags] DIRECTORY|MANIFEST")
+		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "MANIFEST is a .jsonl or .csv file listing the input, the output and the settings of each document.")
+		fmt.Fprintln(fs.Output(), "")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&outputDir, "o", "", "when converting a directory, where to save the PDFs (by default, next to the HTML files)")
+	fs.BoolVar(&opts.recursive, "r", false, "when converting a directory, look for HTML files in subdirectories too")
+	fs.IntVar(&concurrency, "j", 4, "number of documents converted at the same time")
+	fs.BoolVar(&resume, "resume", false, "skip the documents whose PDF already exists")
+	fs.StringVar(&reportPath, "report", "", "write a JSON report of the batch to this file (- for the standard output)")
+	opts.registerFlags(fs)
+
+	args, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+	if len(args) != 1 {
+		fs.Usage()
+		return 2
+	}
+
+	setupLogger(opts.verbose)
+
+	params, err := opts.params()
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	var items []lazypress.BatchItem
+	info, err := os.Stat(args[0])
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if info.IsDir() {
+		items, err = lazypress.DirectoryBatch(args[0], outputDir, opts.recursive)
+	} else {
+		items, err = lazypress.LoadManifest(args[0])
+	}
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	if len(items) == 0 {
+		log.Println("nothing to convert")
+		return 0
+	}
+
+	allocatorCtx, cancel := newAllocator(opts.chromePath)
+	defer cancel()
+	report, err := lazypress.Batch(allocatorCtx, items, lazypress.BatchOptions{
+		Concurrency: concurrency,
+		Settings:    params,
+		Resume:      resume,
+		Timeout:     opts.timeout,
+	})
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+
+	for _, result := range report.Results {
+		if result.Status == lazypress.BatchFailed {
+			log.Printf("%s: %s", result.Input, result.Error)
+		}
+	}
+	log.Printf("%d converted, %d skipped, %d failed in %s",
+		report.Succeeded, report.Skipped, report.Failed, report.Finished.Sub(report.Started).Round(time.Millisecond))
+
+	if reportPath != "" {
+		if err := writeReport(report, reportPath); err != nil {
+			log.Println(err)
+			return 1
+		}
+	}
+	if report.Failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func writeReport(report *lazypress.BatchReport, reportPath string) error {
+	if reportPath == "-" {
+		return report.WriteJSON(os.Stdout)
+	}
+	f, err := os.Create(reportPath)
+	if err != nil {
+		return err
+	}
+	if err := report.WriteJSON(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
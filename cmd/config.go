@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/alexferrari88/lazypress"
+)
+
+func config(args []string) int {
+	if len(args) == 0 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: lazypress config check [--config FILE]")
+		return 2
+	}
+	fs := flag.NewFlagSet("config check", flag.ContinueOnError)
+	configPath := fs.String("config", os.Getenv("LAZYPRESS_CONFIG"), "path to a YAML configuration file")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := lazypress.LoadConfig(*configPath)
+	if err != nil {
+		log.Printf("invalid configuration:\n%v", err)
+		return 1
+	}
+	// do not print the secrets
+	for i := range cfg.Auth.Tokens {
+		cfg.Auth.Tokens[i] = "********"
+	}
+	if cfg.Output.SigningKey != "" {
+		cfg.Output.SigningKey = "********"
+	}
+	if err := cfg.WriteYAML(os.Stdout); err != nil {
+		log.Println(err)
+		return 1
+	}
+	log.Println("configuration is valid")
+	return 0
+}
@@ -0,0 +1,625 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/alexferrari88/lazypress"
+	"github.com/chromedp/chromedp"
+)
+
+// input is a document to convert.
+type input struct {
+	// name is how the input was given, used in messages
+	name string
+	// path is set for local files
+	path string
+	// url is set for remote pages
+	url string
+	// stdin is set when the HTML is read from the standard input
+	stdin bool
+}
+
+// inputFormats are the formats of the documents rendered to HTML before the conversion, by file extension.
+var inputFormats = map[string]string{
+	".md":       "markdown",
+	".markdown": "markdown",
+	".txt":      "text",
+	".log":      "text",
+	".eml":      "email",
+	".mht":      "mhtml",
+	".mhtml":    "mhtml",
+}
+
+// format returns the format of the document of the input: html, or one of inputFormats.
+func (in input) format(opts convertOptions) string {
+	if in.stdin {
+		return cmp.Or(opts.from, "html")
+	}
+	if in.path == "" {
+		return "html"
+	}
+	return cmp.Or(inputFormats[strings.ToLower(filepath.Ext(in.path))], "html")
+}
+
+type convertOptions struct {
+	output          string
+	chromePath      string
+	landscape       bool
+	margin          string
+	paperWidth      string
+	paperHeight     string
+	scale           float64
+	printBackground bool
+	header          string
+	footer          string
+	pageTemplates   map[string]string // templates of the first, odd and even pages, by parameter
+	pageRanges      string
+	preferCSSPage   bool
+	sanitize        bool
+	recursive       bool
+	timeout         time.Duration
+	verbose         bool
+	vars            map[string]string
+	timezone        string
+	locale          string
+	toc             bool
+	tocTitle        string
+	tocLevels       int
+	tocTemplate     string
+	fonts           string
+	from            string
+	theme           string
+	lineNumbers     bool
+	fontFaces       string // @font-face rules of the fonts directory
+}
+
+func convert(args []string) int {
+	var opts convertOptions
+	fs := flag.NewFlagSet("convert", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: lazypress convert [flags] INPUT...")
+		fmt.Fprintln(fs.Output(), "")
+		fmt.Fprintln(fs.Output(), "INPUT can be an HTML, Markdown (.md), text (.txt, .log), email (.eml) or MHTML (.mht) file, a directory, a glob, a URL or - for the standard input (the default).")
+		fmt.Fprintln(fs.Output(), "")
+		fs.PrintDefaults()
+	}
+	fs.StringVar(&opts.output, "o", "", "output file, directory, or - for the standard output")
+	fs.StringVar(&opts.output, "output", "", "same as -o")
+	fs.BoolVar(&opts.recursive, "r", false, "look for documents in subdirectories too")
+	opts.registerFlags(fs)
+	opts.registerInputFlags(fs)
+
+	args, err := parseInterspersed(fs, args)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		return 2
+	}
+
+	setupLogger(opts.verbose)
+
+	params, err := opts.params()
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	if opts.fonts != "" {
+		if opts.fontFaces, err = (&lazypress.FontDir{Dir: opts.fonts}).FontFaceCSS(); err != nil {
+			log.Println("could not load fonts:", err)
+			return 2
+		}
+	}
+
+	inputs, err := expandInputs(args, opts.recursive)
+	if err != nil {
+		log.Println(err)
+		return 1
+	}
+	outputs, err := outputPaths(inputs, opts.output)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	allocatorCtx, cancelAllocator := newAllocator(opts.chromePath)
+	defer cancelAllocator()
+	// all the inputs are converted in tabs of the same browser
+	browserCtx, cancel := chromedp.NewContext(allocatorCtx)
+	defer cancel()
+	if err := chromedp.Run(browserCtx); err != nil {
+		log.Println("could not start chrome:", err)
+		return 1
+	}
+
+	failed := 0
+	for i, in := range inputs {
+		if err := convertInput(browserCtx, in, outputs[i], params, opts); err != nil {
+			log.Printf("%s: %v", in.name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		log.Printf("%d of %d conversions failed", failed, len(inputs))
+		return 1
+	}
+	return 0
+}
+
+// registerFlags registers the flags shared by the commands converting documents.
+func (opts *convertOptions) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&opts.chromePath, "chrome", "", "path to chrome (by default, it is looked up in the usual locations)")
+	fs.BoolVar(&opts.landscape, "landscape", false, "use landscape orientation")
+	fs.StringVar(&opts.margin, "margin", "", `margins like the CSS shorthand, e.g. "1cm" or "1cm 2cm" (units: in, cm, mm, px, pt)`)
+	fs.StringVar(&opts.paperWidth, "paper-width", "", "paper width, e.g. 21cm")
+	fs.StringVar(&opts.paperHeight, "paper-height", "", "paper height, e.g. 29.7cm")
+	fs.Float64Var(&opts.scale, "scale", 0, "scale of the webpage rendering")
+	fs.BoolVar(&opts.printBackground, "print-background", false, "print background graphics")
+	fs.StringVar(&opts.header, "header", "", "HTML template for the header, or @FILE to read it from a file")
+	fs.StringVar(&opts.footer, "footer", "", "HTML template for the footer, or @FILE to read it from a file")
+	for _, flagName := range []string{"first-header", "first-footer", "odd-header", "odd-footer", "even-header", "even-footer"} {
+		page, part, _ := strings.Cut(flagName, "-")
+		param := page + strings.ToUpper(part[:1]) + part[1:] + "Template"
+		usage := fmt.Sprintf("HTML template for the %s of the %s pages, or @FILE (empty for none)", part, page)
+		if page == "first" {
+			usage = fmt.Sprintf("HTML template for the %s of the first page, or @FILE (empty for none)", part)
+		}
+		fs.Func(flagName, usage, func(value string) error {
+			if opts.pageTemplates == nil {
+				opts.pageTemplates = map[string]string{}
+			}
+			opts.pageTemplates[param] = value
+			return nil
+		})
+	}
+	fs.Func("var", "custom value for the {{var.NAME}} placeholders of the templates, as NAME=VALUE (repeatable)", func(value string) error {
+		name, value, ok := strings.Cut(value, "=")
+		if !ok || name == "" {
+			return fmt.Errorf("expected NAME=VALUE, got %q", value)
+		}
+		if opts.vars == nil {
+			opts.vars = map[string]string{}
+		}
+		opts.vars[name] = value
+		return nil
+	})
+	fs.StringVar(&opts.timezone, "timezone", "", "time zone of the dates of the templates, e.g. Europe/Paris")
+	fs.StringVar(&opts.locale, "locale", "", "locale of the dates of the templates, e.g. fr")
+	fs.BoolVar(&opts.toc, "toc", false, "generate a table of contents from the headings, with their page numbers")
+	fs.StringVar(&opts.tocTitle, "toc-title", "", "title of the table of contents (default \"Contents\")")
+	fs.IntVar(&opts.tocLevels, "toc-levels", 0, "deepest heading level listed in the table of contents, from 1 to 6 (default 3)")
+	fs.StringVar(&opts.tocTemplate, "toc-template", "", "HTML template for the entries of the table of contents, or @FILE to read it from a file")
+	fs.StringVar(&opts.pageRanges, "page-ranges", "", "pages to print, e.g. '1-5, 8, 11-13'")
+	fs.BoolVar(&opts.preferCSSPage, "prefer-css-page-size", false, "prefer the page size defined by CSS")
+	fs.BoolVar(&opts.sanitize, "sanitize", false, "sanitize the HTML to remove potentially malicious code")
+	fs.DurationVar(&opts.timeout, "timeout", 2*time.Minute, "maximum time to convert each input")
+	fs.BoolVar(&opts.verbose, "v", false, "log what lazypress is doing")
+}
+
+// registerInputFlags registers the flags about the formats of the documents and their fonts,
+// which only convert honours: batch converts HTML files and URLs.
+func (opts *convertOptions) registerInputFlags(fs *flag.FlagSet) {
+	fs.Func("from", "format of the standard input: html (the default), markdown, text, email or mhtml", func(value string) error {
+		if value != "html" && !slices.Contains(slices.Collect(maps.Values(inputFormats)), value) {
+			return fmt.Errorf("%q is not one of html, markdown, text, email or mhtml", value)
+		}
+		opts.from = value
+		return nil
+	})
+	fs.StringVar(&opts.theme, "theme", "", fmt.Sprintf("theme of the Markdown documents: %s (default %q)", strings.Join(lazypress.MarkdownThemes(), ", "), lazypress.DefaultMarkdownTheme))
+	fs.BoolVar(&opts.lineNumbers, "line-numbers", false, "number the lines of the text documents")
+	fs.StringVar(&opts.fonts, "fonts", "", "directory of .ttf, .otf, .woff and .woff2 fonts to make available to the documents")
+}
+
+func setupLogger(verbose bool) {
+	level := slog.LevelWarn
+	if verbose {
+		level = slog.LevelInfo
+	}
+	lazypress.SetLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+}
+
+// newAllocator returns the context to start Chrome from.
+// Without chromePath, chromedp looks for Chrome in the usual locations.
+func newAllocator(chromePath string) (context.Context, context.CancelFunc) {
+	if chromePath == "" {
+		return context.WithCancel(context.Background())
+	}
+	return chromedp.NewExecAllocator(
+		context.Background(),
+		append([]chromedp.ExecAllocatorOption{chromedp.ExecPath(chromePath)}, chromedp.DefaultExecAllocatorOptions[:]...)...,
+	)
+}
+
+// parseInterspersed parses the flags even when they come after the positional arguments,
+// e.g. "lazypress convert in.html -o out.pdf".
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// params turns the options into the settings understood by PDF.LoadSettings.
+func (opts convertOptions) params() (map[string]string, error) {
+	params := map[string]string{}
+	formatInches := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	if opts.landscape {
+		params["landscape"] = "true"
+	}
+	if opts.margin != "" {
+		top, right, bottom, left, err := lazypress.ParseMargins(opts.margin)
+		if err != nil {
+			return nil, err
+		}
+		params["marginTop"] = formatInches(top)
+		params["marginRight"] = formatInches(right)
+		params["marginBottom"] = formatInches(bottom)
+		params["marginLeft"] = formatInches(left)
+	}
+	for key, value := range map[string]string{"paperWidth": opts.paperWidth, "paperHeight": opts.paperHeight} {
+		if value == "" {
+			continue
+		}
+		length, err := lazypress.ParseLength(value)
+		if err != nil {
+			return nil, err
+		}
+		params[key] = formatInches(length)
+	}
+	if opts.scale != 0 {
+		params["scale"] = strconv.FormatFloat(opts.scale, 'f', -1, 64)
+	}
+	if opts.printBackground {
+		params["printBackground"] = "true"
+	}
+	if opts.header != "" || opts.footer != "" {
+		params["displayHeaderFooter"] = "true"
+		// Chrome prints its default header and footer when a template is empty
+		params["headerTemplate"] = "<span></span>"
+		params["footerTemplate"] = "<span></span>"
+		for key, template := range map[string]string{"headerTemplate": opts.header, "footerTemplate": opts.footer} {
+			if template == "" {
+				continue
+			}
+			template, err := readTemplate(template)
+			if err != nil {
+				return nil, err
+			}
+			params[key] = template
+		}
+	}
+	for key, template := range opts.pageTemplates {
+		template, err := readTemplate(template)
+		if err != nil {
+			return nil, err
+		}
+		params[key] = template
+	}
+	for name, value := range opts.vars {
+		params["var."+name] = value
+	}
+	if opts.timezone != "" {
+		params["timezone"] = opts.timezone
+	}
+	if opts.locale != "" {
+		params["locale"] = opts.locale
+	}
+	if opts.toc {
+		params["toc"] = "true"
+	}
+	if opts.tocTitle != "" {
+		params["tocTitle"] = opts.tocTitle
+	}
+	if opts.tocLevels != 0 {
+		params["tocLevels"] = strconv.Itoa(opts.tocLevels)
+	}
+	if opts.tocTemplate != "" {
+		template, err := readTemplate(opts.tocTemplate)
+		if err != nil {
+			return nil, err
+		}
+		params["tocTemplate"] = template
+	}
+	if opts.pageRanges != "" {
+		params["pageRanges"] = opts.pageRanges
+	}
+	if opts.preferCSSPage {
+		params["preferCSSPageSize"] = "true"
+	}
+	if opts.sanitize {
+		params["sanitize"] = "true"
+	}
+	return params, nil
+}
+
+// readTemplate returns the template, or the content of the file it names with @FILE.
+func readTemplate(template string) (string, error) {
+	name, ok := strings.CutPrefix(template, "@")
+	if !ok {
+		return template, nil
+	}
+	content, err := os.ReadFile(name)
+	if err != nil {
+		return "", fmt.Errorf("could not read template: %v", err)
+	}
+	return string(content), nil
+}
+
+// expandInputs resolves the arguments to the list of documents to convert.
+// Without arguments, the HTML is read from the standard input.
+func expandInputs(args []string, recursive bool) ([]input, error) {
+	if len(args) == 0 {
+		return []input{{name: "-", stdin: true}}, nil
+	}
+	var inputs []input
+	for _, arg := range args {
+		switch {
+		case arg == "-":
+			inputs = append(inputs, input{name: "-", stdin: true})
+		case lazypress.IsURL(arg):
+			inputs = append(inputs, input{name: arg, url: arg})
+		case strings.ContainsAny(arg, "*?["):
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			if len(matches) == 0 {
+				return nil, fmt.Errorf("%s: no files match", arg)
+			}
+			for _, match := range matches {
+				found, err := expandPath(match, recursive)
+				if err != nil {
+					return nil, err
+				}
+				inputs = append(inputs, found...)
+			}
+		default:
+			found, err := expandPath(arg, recursive)
+			if err != nil {
+				return nil, err
+			}
+			inputs = append(inputs, found...)
+		}
+	}
+	if len(inputs) == 0 {
+		return nil, fmt.Errorf("no documents found")
+	}
+	return inputs, nil
+}
+
+// expandPath returns the file itself or the documents in the directory: HTML files and the files of inputFormats,
+// except text files, which are converted only when they are named.
+func expandPath(p string, recursive bool) ([]input, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []input{{name: p, path: p}}, nil
+	}
+	var inputs []input
+	err = filepath.WalkDir(p, func(walked string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if walked != p && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isDocumentFile(walked) {
+			inputs = append(inputs, input{name: walked, path: walked})
+		}
+		return nil
+	})
+	return inputs, err
+}
+
+func isDocumentFile(p string) bool {
+	ext := strings.ToLower(filepath.Ext(p))
+	format, ok := inputFormats[ext]
+	return ext == ".html" || ext == ".htm" || (ok && format != "text")
+}
+
+// outputPaths returns where to write the PDF of each input, "-" meaning the standard output.
+// With a single input, output can be a file. With more inputs, it must be a directory.
+// Without output, PDFs of files are written next to them and the other PDFs to the standard output.
+func outputPaths(inputs []input, output string) ([]string, error) {
+	outputs := make([]string, len(inputs))
+	outputIsDir := false
+	if output != "" && output != "-" {
+		if info, err := os.Stat(output); err == nil && info.IsDir() {
+			outputIsDir = true
+		} else if len(inputs) > 1 || strings.HasSuffix(output, string(os.PathSeparator)) {
+			if err := os.MkdirAll(output, 0o755); err != nil {
+				return nil, err
+			}
+			outputIsDir = true
+		}
+	}
+	stdout := 0
+	for i, in := range inputs {
+		switch {
+		case outputIsDir:
+			outputs[i] = filepath.Join(output, pdfName(in))
+		case output != "":
+			outputs[i] = output
+		case in.path != "":
+			outputs[i] = strings.TrimSuffix(in.path, filepath.Ext(in.path)) + ".pdf"
+		default:
+			outputs[i] = "-"
+		}
+		if outputs[i] == "-" {
+			stdout++
+		}
+	}
+	if stdout > 1 {
+		return nil, fmt.Errorf("only one PDF can be written to the standard output: use -o with a directory")
+	}
+	return outputs, nil
+}
+
+// pdfName returns the file name of the PDF of an input.
+func pdfName(in input) string {
+	switch {
+	case in.path != "":
+		base := filepath.Base(in.path)
+		return strings.TrimSuffix(base, filepath.Ext(base)) + ".pdf"
+	case in.url != "":
+		u, err := url.Parse(in.url)
+		if err != nil {
+			return "page.pdf"
+		}
+		name := strings.Trim(u.Host+u.Path, "/")
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		name = strings.Map(func(r rune) rune {
+			if r == '/' || r == '\\' || r == ':' {
+				return '_'
+			}
+			return r
+		}, name)
+		if name == "" {
+			name = "page"
+		}
+		return name + ".pdf"
+	}
+	return "stdin.pdf"
+}
+
+// renderInput renders a document that is not HTML to HTML, e.g. a Markdown document, and sets the properties of its PDF.
+func renderInput(in input, p *lazypress.PDF, opts convertOptions) ([]byte, error) {
+	source, err := readInput(in)
+	if err != nil {
+		return nil, err
+	}
+	var html []byte
+	switch in.format(opts) {
+	case "markdown":
+		mdOpts := lazypress.MarkdownOptions{Theme: opts.theme, Safe: p.Sanitize}
+		if in.path != "" {
+			// resolve the relative links to images against the directory of the file
+			dir, err := filepath.Abs(filepath.Dir(in.path))
+			if err != nil {
+				return nil, err
+			}
+			mdOpts.BaseURL = (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir) + "/"}).String()
+		}
+		doc, err := lazypress.RenderMarkdown(source, mdOpts)
+		if err != nil {
+			return nil, err
+		}
+		if doc.Author != "" {
+			p.Properties = map[string]string{"Author": doc.Author}
+		}
+		return doc.HTML, nil
+	case "text":
+		return lazypress.RenderPlainText(source, lazypress.TextOptions{LineNumbers: opts.lineNumbers}), nil
+	case "email":
+		html, err = lazypress.RenderEmail(source)
+	case "mhtml":
+		// the archive is a whole MIME message, so its content type comes from its headers
+		html, err = lazypress.RenderMHTML(source, "")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if p.Sanitize {
+		html = lazypress.SanitizeHTML(html)
+	}
+	return html, nil
+}
+
+func convertInput(browserCtx context.Context, in input, output string, params map[string]string, opts convertOptions) error {
+	p := lazypress.PDF{FontFaces: opts.fontFaces}
+	if err := p.LoadSettings(params, nil, nil); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(browserCtx, opts.timeout)
+	defer cancel()
+
+	var renderErr error
+	switch {
+	case in.format(opts) != "html":
+		html, err := renderInput(in, &p, opts)
+		if err != nil {
+			return err
+		}
+		renderErr = p.Render(ctx, html)
+	case in.stdin || (in.path != "" && p.Sanitize):
+		html, err := readInput(in)
+		if err != nil {
+			return err
+		}
+		if p.Sanitize {
+			html = lazypress.SanitizeHTML(html)
+		}
+		renderErr = p.Render(ctx, html)
+	case in.path != "":
+		// load the file from disk, so that relative links to images and stylesheets work
+		abs, err := filepath.Abs(in.path)
+		if err != nil {
+			return err
+		}
+		renderErr = p.RenderURL(ctx, (&url.URL{Scheme: "file", Path: filepath.ToSlash(abs)}).String())
+	default:
+		renderErr = p.RenderURL(ctx, in.url)
+	}
+	if renderErr != nil {
+		return fmt.Errorf("could not generate PDF: %w", renderErr)
+	}
+
+	if output == "-" {
+		p.Exporter = os.Stdout
+		p.Closer = nil
+	} else {
+		file, err := os.Create(output)
+		if err != nil {
+			return err
+		}
+		p.Exporter = file
+		p.Closer = file
+	}
+	if err := p.Export(); err != nil {
+		return err
+	}
+	if opts.verbose && output != "-" {
+		log.Printf("%s: saved to %s", in.name, output)
+	}
+	return nil
+}
+
+func readInput(in input) ([]byte, error) {
+	if in.stdin {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(in.path)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/chromedp/chromedp"
+)
+
+func TestShouldParseFlagsAfterInputs(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	output := fs.String("o", "", "")
+	landscape := fs.Bool("landscape", false, "")
+	args, err := parseInterspersed(fs, []string{"in.html", "-o", "out.pdf", "--landscape", "other.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args, []string{"in.html", "other.html"}) {
+		t.Errorf("Expected inputs to be in.html and other.html, got %v", args)
+	}
+	if *output != "out.pdf" || !*landscape {
+		t.Error("Expected flags after inputs to be parsed")
+	}
+}
+
+func TestShouldExpandDirectoriesAndGlobs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.html", "b.htm", "notes.txt", filepath.Join("sub", "c.html")} {
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("<html></html>"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	inputs, err := expandInputs([]string{dir}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 2 {
+		t.Errorf("Expected 2 HTML files in the directory, got %v", inputs)
+	}
+
+	inputs, err = expandInputs([]string{dir}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 3 {
+		t.Errorf("Expected 3 HTML files in the directory and its subdirectories, got %v", inputs)
+	}
+
+	inputs, err = expandInputs([]string{filepath.Join(dir, "*.html"), "https://example.com/report.html", "-"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inputs) != 3 || inputs[1].url == "" || !inputs[2].stdin {
+		t.Errorf("Expected a file, a URL and the standard input, got %v", inputs)
+	}
+}
+
+func TestShouldChooseOutputPaths(t *testing.T) {
+	dir := t.TempDir()
+	inputs := []input{
+		{name: "docs/a.html", path: "docs/a.html"},
+		{name: "https://example.com/reports/q1.html", url: "https://example.com/reports/q1.html"},
+	}
+	outputs, err := outputPaths(inputs, filepath.Join(dir, "out"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{filepath.Join(dir, "out", "a.pdf"), filepath.Join(dir, "out", "example.com_reports_q1.pdf")}
+	if !reflect.DeepEqual(outputs, expected) {
+		t.Errorf("Expected outputs to be %v, got %v", expected, outputs)
+	}
+
+	outputs, err = outputPaths(inputs[:1], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs[0] != "docs/a.pdf" {
+		t.Errorf("Expected the PDF to be next to the input, got %s", outputs[0])
+	}
+
+	if _, err := outputPaths([]input{{stdin: true}, {url: "https://example.com"}}, "-"); err == nil {
+		t.Error("Expected an error when writing several PDFs to the standard output")
+	}
+}
+
+func TestShouldTurnOptionsIntoSettings(t *testing.T) {
+	opts := convertOptions{landscape: true, margin: "1in 2in", footer: "<span class=pageNumber></span>"}
+	params, err := opts.params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"landscape":           "true",
+		"marginTop":           "1",
+		"marginRight":         "2",
+		"marginBottom":        "1",
+		"marginLeft":          "2",
+		"displayHeaderFooter": "true",
+		"headerTemplate":      "<span></span>",
+		"footerTemplate":      "<span class=pageNumber></span>",
+	}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected settings to be %v, got %v", expected, params)
+	}
+}
+
+func TestShouldReadTemplatesFromFiles(t *testing.T) {
+	header := filepath.Join(t.TempDir(), "header.html")
+	if err := os.WriteFile(header, []byte("<span>{{var.client}}</span>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	opts := convertOptions{header: "@" + header, vars: map[string]string{"client": "ACME"}, locale: "fr"}
+	params, err := opts.params()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["headerTemplate"] != "<span>{{var.client}}</span>" {
+		t.Errorf("Expected the header to be read from the file, got %q", params["headerTemplate"])
+	}
+	if params["var.client"] != "ACME" || params["locale"] != "fr" {
+		t.Errorf("Expected the template values to be passed on, got %v", params)
+	}
+
+	opts.header = "@" + filepath.Join(t.TempDir(), "missing.html")
+	if _, err := opts.params(); err == nil {
+		t.Error("Expected an error for a missing template")
+	}
+}
+
+func TestShouldRejectInputFlagsInBatches(t *testing.T) {
+	for _, args := range [][]string{{"--fonts", "fonts"}, {"--from", "markdown"}, {"--theme", "github"}, {"--line-numbers"}} {
+		if code := batch(append(args, t.TempDir())); code != 2 {
+			t.Errorf("Expected batch to reject %s, got exit code %d", args[0], code)
+		}
+	}
+}
+
+func TestShouldReturnTheCauseOfFailedConversions(t *testing.T) {
+	allocatorCtx, cancel := newAllocator(filepath.Join(t.TempDir(), "chrome"))
+	defer cancel()
+	browserCtx, cancel := chromedp.NewContext(allocatorCtx)
+	defer cancel()
+	in := input{name: "page", url: "https://example.com"}
+	err := convertInput(browserCtx, in, filepath.Join(t.TempDir(), "page.pdf"), nil, convertOptions{timeout: time.Minute})
+	if err == nil {
+		t.Fatal("Expected the conversion to fail without chrome")
+	}
+	if errors.Unwrap(err) == nil {
+		t.Errorf("Expected the error to wrap its cause, got %v", err)
+	}
+}
@@ -1,7 +1,9 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"path"
@@ -9,14 +11,90 @@ import (
 	"github.com/alexferrari88/lazypress"
 )
 
+const usage = `Usage:
+  lazypress [serve] [flags]                start the server
+  lazypress convert [flags] INPUT...       convert HTML files, directories, globs or URLs to PDF
+  lazypress batch [flags] DIR|MANIFEST     convert a directory or the documents listed in a JSONL/CSV manifest
+  lazypress config check [--config FILE]   validate the server configuration and print it
+
+Run "lazypress COMMAND --help" to see the flags of a command.
+`
+
 func main() {
-	dir, dirError := os.Getwd()
-	if dirError != nil {
-		log.Fatalln(dirError)
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "convert":
+			os.Exit(convert(os.Args[2:]))
+		case "batch":
+			os.Exit(batch(os.Args[2:]))
+		case "config":
+			os.Exit(config(os.Args[2:]))
+		case "help", "-h", "--help":
+			fmt.Fprint(os.Stderr, usage)
+			return
+		}
+	}
+	// without a command, start the server like lazypress always did
+	serve(os.Args[1:])
+}
+
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	configPath := fs.String("config", os.Getenv("LAZYPRESS_CONFIG"), "path to a YAML configuration file")
+	port := fs.Int("port", 3444, "port to listen on")
+	chromePath := fs.String("chrome", "", "path to chrome")
+	otlpEndpoint := fs.String("otlp-endpoint", "", "OpenTelemetry collector to send traces to (host:port or URL)")
+	fs.Parse(args)
+
+	cfg, err := lazypress.LoadConfig(*configPath)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	// flags take precedence over the configuration file and the environment
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "port":
+			cfg.Port = *port
+		case "chrome":
+			cfg.Chrome.Path = *chromePath
+		case "otlp-endpoint":
+			cfg.Tracing.OTLPEndpoint = *otlpEndpoint
+		}
+	})
+	if cfg.Chrome.Path == "" {
+		cfg.Chrome.Path = legacyChromePath()
 	}
-	port := flag.Int("port", 3444, "port to listen on")
-	chromePath := flag.String("chrome", path.Join(dir, "chrome-linux", "chrome"), "path to chrome")
-	flag.Parse()
 
-	lazypress.InitServer(*port, *chromePath)
+	if cfg.Tracing.OTLPEndpoint != "" || os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		shutdown, err := lazypress.InitTracing(context.Background(), cfg.Tracing.OTLPEndpoint)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer shutdown(context.Background())
+	}
+
+	s, err := lazypress.NewServerFromConfig(cfg)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if err := s.Run(); err != nil {
+		log.Fatalln(err)
+	}
+}
+
+// legacyChromePath returns the chrome downloaded by get_latest_chromium.sh in the working directory, if any.
+// Otherwise, chromedp looks for Chrome in the usual locations.
+func legacyChromePath() string {
+	dir, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	chromePath := path.Join(dir, "chrome-linux", "chrome")
+	if _, err := os.Stat(chromePath); err != nil {
+		return ""
+	}
+	return chromePath
 }
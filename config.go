@@ -0,0 +1,443 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server.
+// It can be loaded from a YAML file with LoadConfig and overridden with LAZYPRESS_* environment variables.
+// The name of the environment variable of each setting is in its env tag.
+type Config struct {
+	Port   int          `yaml:"port" env:"LAZYPRESS_PORT"`
+	Chrome ChromeConfig `yaml:"chrome"`
+	// Defaults are the settings used when a request does not specify them.
+	// They use the same keys as the query parameters (see LoadSettings),
+	// and can be set with LAZYPRESS_DEFAULT_<KEY> environment variables (e.g. LAZYPRESS_DEFAULT_PRINTBACKGROUND=true).
+	Defaults map[string]string `yaml:"defaults" env:"LAZYPRESS_DEFAULT_"`
+	// Profiles are named sets of settings, selected by the requests with the profile parameter
+	// (e.g. profile=invoice-a4). They override the defaults and are overridden by the other parameters of the request.
+	// They can only be set in the configuration file.
+	Profiles  map[string]map[string]string `yaml:"profiles"`
+	Sanitize  SanitizeConfig               `yaml:"sanitize"`
+	Output    OutputConfig                 `yaml:"output"`
+	Limits    LimitsConfig                 `yaml:"limits"`
+	Queue     QueueConfig                  `yaml:"queue"`
+	Templates TemplatesConfig              `yaml:"templates"`
+	Fonts     FontsConfig                  `yaml:"fonts"`
+	Cache     CacheConfig                  `yaml:"cache"`
+	Auth      AuthConfig                   `yaml:"auth"`
+	Tracing   TracingConfig                `yaml:"tracing"`
+}
+
+// ChromeConfig configures the Chrome process.
+type ChromeConfig struct {
+	// Path of the chrome executable. If empty, Chrome is looked up in the usual locations.
+	Path string `yaml:"path" env:"LAZYPRESS_CHROME_PATH"`
+	// Flags are passed to Chrome, as name or name=value (e.g. no-sandbox, window-size=1920,1080).
+	// In the environment variable, they are separated by spaces.
+	Flags []string `yaml:"flags" env:"LAZYPRESS_CHROME_FLAGS"`
+}
+
+// SanitizeConfig configures the HTML sanitizer.
+type SanitizeConfig struct {
+	// Policy is the bluemonday policy to use: "ugc" (the default) keeps the formatting, "strict" keeps only the text.
+	Policy string `yaml:"policy" env:"LAZYPRESS_SANITIZE_POLICY"`
+	// Always sanitizes every request, whatever its sanitize parameter.
+	Always bool `yaml:"always" env:"LAZYPRESS_SANITIZE_ALWAYS"`
+}
+
+// OutputConfig configures where the PDFs go.
+type OutputConfig struct {
+	// Default is the output used when a request does not specify one. It defaults to download.
+	Default string `yaml:"default" env:"LAZYPRESS_OUTPUT_DEFAULT"`
+	// Allowed lists the outputs clients can choose. If empty, all of them are allowed.
+	Allowed []string `yaml:"allowed" env:"LAZYPRESS_OUTPUT_ALLOWED"`
+	// Dir is where the file output saves the PDFs. See FileExporter for this setting and the next ones.
+	Dir string `yaml:"dir" env:"LAZYPRESS_OUTPUT_DIR"`
+	// Naming is the template of the file names, e.g. {date}/{filename}-{uuid}.
+	Naming string `yaml:"naming" env:"LAZYPRESS_OUTPUT_NAMING"`
+	// Overwrite is the policy when a file already exists: rename, replace or error.
+	Overwrite string `yaml:"overwrite" env:"LAZYPRESS_OUTPUT_OVERWRITE"`
+	// Retention is how long the files are kept. 0 keeps them forever.
+	Retention time.Duration `yaml:"retention" env:"LAZYPRESS_OUTPUT_RETENTION"`
+	// SigningKey signs the URLs of the files, so that they can be downloaded without a token until they expire.
+	// If empty, the URLs are not signed.
+	SigningKey string `yaml:"signingKey" env:"LAZYPRESS_OUTPUT_SIGNING_KEY"`
+	// URLExpiry is how long a signed URL is valid.
+	URLExpiry time.Duration `yaml:"urlExpiry" env:"LAZYPRESS_OUTPUT_URL_EXPIRY"`
+}
+
+// LimitsConfig configures the limits of the server.
+type LimitsConfig struct {
+	// MaxBodyBytes is the maximum size of a request body. 0 means no limit.
+	MaxBodyBytes    int64         `yaml:"maxBodyBytes" env:"LAZYPRESS_LIMITS_MAX_BODY_BYTES"`
+	ReadTimeout     time.Duration `yaml:"readTimeout" env:"LAZYPRESS_LIMITS_READ_TIMEOUT"`
+	WriteTimeout    time.Duration `yaml:"writeTimeout" env:"LAZYPRESS_LIMITS_WRITE_TIMEOUT"`
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout" env:"LAZYPRESS_LIMITS_SHUTDOWN_TIMEOUT"`
+	// LoadTimeout, PrintTimeout and RenderTimeout bound the loading of the page, its printing and the whole render.
+	// 0 means no limit. See RenderLimits.
+	LoadTimeout   time.Duration `yaml:"loadTimeout" env:"LAZYPRESS_LIMITS_LOAD_TIMEOUT"`
+	PrintTimeout  time.Duration `yaml:"printTimeout" env:"LAZYPRESS_LIMITS_PRINT_TIMEOUT"`
+	RenderTimeout time.Duration `yaml:"renderTimeout" env:"LAZYPRESS_LIMITS_RENDER_TIMEOUT"`
+	// MaxHTMLBytes is the maximum size of the HTML to render, once sanitized. 0 means no limit.
+	MaxHTMLBytes int `yaml:"maxHTMLBytes" env:"LAZYPRESS_LIMITS_MAX_HTML_BYTES"`
+	// MaxPages is the maximum number of pages of a PDF. 0 means no limit. Streamed PDFs are not checked.
+	MaxPages int `yaml:"maxPages" env:"LAZYPRESS_LIMITS_MAX_PAGES"`
+	// MaxPDFBytes is the maximum size of a PDF. 0 means no limit.
+	MaxPDFBytes int64 `yaml:"maxPDFBytes" env:"LAZYPRESS_LIMITS_MAX_PDF_BYTES"`
+}
+
+// renderLimits returns the limits of each render.
+func (l LimitsConfig) renderLimits() RenderLimits {
+	return RenderLimits{
+		LoadTimeout:  l.LoadTimeout,
+		PrintTimeout: l.PrintTimeout,
+		Timeout:      l.RenderTimeout,
+		MaxHTMLBytes: l.MaxHTMLBytes,
+		MaxPages:     l.MaxPages,
+		MaxPDFBytes:  l.MaxPDFBytes,
+	}
+}
+
+// TemplatesConfig configures the header and footer templates stored on the server.
+type TemplatesConfig struct {
+	// Dir is the directory of the templates, chosen by the requests with headerFile and footerFile.
+	Dir string `yaml:"dir" env:"LAZYPRESS_TEMPLATES_DIR"`
+}
+
+// FontsConfig configures the fonts made available to the documents.
+type FontsConfig struct {
+	// Dir is the directory of the font files. Its fonts are added to every document (see FontDir).
+	Dir string `yaml:"dir" env:"LAZYPRESS_FONTS_DIR"`
+	// Upload lets clients add and remove fonts with PUT and DELETE /fonts/{file}.
+	Upload bool `yaml:"upload" env:"LAZYPRESS_FONTS_UPLOAD"`
+}
+
+// QueueConfig configures how many PDFs are rendered at once, and how the other conversions wait for their turn.
+type QueueConfig struct {
+	// MaxConcurrent is the maximum number of renders in progress. 0 means no limit.
+	MaxConcurrent int `yaml:"maxConcurrent" env:"LAZYPRESS_QUEUE_MAX_CONCURRENT"`
+	// MaxWaiting is the maximum number of conversions waiting for a render. Beyond it, they are rejected with 503.
+	MaxWaiting int `yaml:"maxWaiting" env:"LAZYPRESS_QUEUE_MAX_WAITING"`
+	// Timeout is how long a conversion waits for a render at most. Requests can ask for less with queueTimeout.
+	Timeout time.Duration `yaml:"timeout" env:"LAZYPRESS_QUEUE_TIMEOUT"`
+	// RetryAfter is sent in the Retry-After header of the rejected conversions.
+	RetryAfter time.Duration `yaml:"retryAfter" env:"LAZYPRESS_QUEUE_RETRY_AFTER"`
+}
+
+// CacheConfig configures the render cache (see RenderCache).
+type CacheConfig struct {
+	// Backend is where the PDFs are cached: "memory", "disk", or "" to disable the cache.
+	Backend string `yaml:"backend" env:"LAZYPRESS_CACHE_BACKEND"`
+	// Dir is the directory of the disk cache. It defaults to a lazypress directory in the user cache directory.
+	Dir string `yaml:"dir" env:"LAZYPRESS_CACHE_DIR"`
+	// MaxBytes is the maximum size of the cached PDFs.
+	MaxBytes int64 `yaml:"maxBytes" env:"LAZYPRESS_CACHE_MAX_BYTES"`
+	// TTL is how long a PDF stays in the cache. 0 keeps it until it is evicted.
+	TTL time.Duration `yaml:"ttl" env:"LAZYPRESS_CACHE_TTL"`
+}
+
+// Cache backends supported by the server.
+var knownCacheBackends = []string{"", "memory", "disk"}
+
+// AuthConfig configures the authentication of the server.
+type AuthConfig struct {
+	// Tokens are the accepted bearer tokens. If empty, the server does not require authentication.
+	// In the environment variable, they are separated by spaces.
+	Tokens []string `yaml:"tokens" env:"LAZYPRESS_AUTH_TOKENS"`
+}
+
+// TracingConfig configures OpenTelemetry. See InitTracing.
+type TracingConfig struct {
+	OTLPEndpoint string `yaml:"otlpEndpoint" env:"LAZYPRESS_TRACING_OTLP_ENDPOINT"`
+}
+
+// Sanitization policies supported by the server.
+var knownPolicies = []string{"ugc", "strict"}
+
+// DefaultConfig returns the configuration used when nothing is configured.
+func DefaultConfig() Config {
+	return Config{
+		Port:     3444,
+		Sanitize: SanitizeConfig{Policy: "ugc"},
+		Output:   OutputConfig{Default: "download", Naming: DefaultNaming, Overwrite: OverwriteRename, URLExpiry: time.Hour},
+		Queue:    QueueConfig{MaxConcurrent: runtime.NumCPU(), MaxWaiting: 100, Timeout: 30 * time.Second, RetryAfter: 5 * time.Second},
+		Cache:    CacheConfig{MaxBytes: 256 << 20, TTL: time.Hour},
+		Limits: LimitsConfig{
+			ReadTimeout:     30 * time.Second,
+			WriteTimeout:    3 * time.Minute,
+			ShutdownTimeout: 30 * time.Second,
+			LoadTimeout:     30 * time.Second,
+			PrintTimeout:    time.Minute,
+			RenderTimeout:   90 * time.Second,
+		},
+	}
+}
+
+// LoadConfig loads the configuration from a YAML file, on top of DefaultConfig,
+// then applies the LAZYPRESS_* environment variables and validates the result.
+// If path is empty, only the environment variables are used.
+func LoadConfig(path string) (Config, error) {
+	cfg := DefaultConfig()
+	if path != "" {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return cfg, err
+		}
+		dec := yaml.NewDecoder(bytes.NewReader(content))
+		dec.KnownFields(true)
+		if err := dec.Decode(&cfg); err != nil && !errors.Is(err, io.EOF) {
+			return cfg, fmt.Errorf("could not read %s: %v", path, err)
+		}
+	}
+	envErr := cfg.applyEnv(os.Environ())
+	return cfg, errors.Join(envErr, cfg.Validate())
+}
+
+// applyEnv overrides the configuration with the environment variables named in the env tags.
+// environ is in the form returned by os.Environ.
+func (c *Config) applyEnv(environ []string) error {
+	env := make(map[string]string, len(environ))
+	for _, kv := range environ {
+		name, value, _ := strings.Cut(kv, "=")
+		env[name] = value
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := env[name]
+		return value, ok
+	}
+
+	var errs []error
+	applyEnvToStruct(reflect.ValueOf(c).Elem(), lookup, &errs)
+	for name, value := range env {
+		if key, ok := strings.CutPrefix(name, "LAZYPRESS_DEFAULT_"); ok && key != "" {
+			if c.Defaults == nil {
+				c.Defaults = map[string]string{}
+			}
+			c.Defaults[settingKey(key)] = value
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func applyEnvToStruct(v reflect.Value, lookup func(string) (string, bool), errs *[]error) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		fieldVal := v.Field(i)
+		if field.Type.Kind() == reflect.Struct && field.Type != reflect.TypeOf(time.Duration(0)) {
+			applyEnvToStruct(fieldVal, lookup, errs)
+			continue
+		}
+		name := field.Tag.Get("env")
+		if name == "" || strings.HasSuffix(name, "_") {
+			continue
+		}
+		value, ok := lookup(name)
+		if !ok {
+			continue
+		}
+		if err := setFromString(fieldVal, value); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %v", name, err))
+		}
+	}
+}
+
+func setFromString(v reflect.Value, value string) error {
+	if v.Type() == reflect.TypeOf(time.Duration(0)) {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(value)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		v.Set(reflect.ValueOf(strings.Fields(value)))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// settingKey returns the canonical name of a PrintToPDFParams setting, ignoring the case,
+// e.g. PRINTBACKGROUND becomes printBackground. The other parameters of lazypress are matched the same way,
+// and unknown names are returned in lower case.
+func settingKey(name string) string {
+	if key, ok := lookupSettingKey(name); ok {
+		return key
+	}
+	for _, key := range otherParams {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return strings.ToLower(name)
+}
+
+// lookupSettingKey returns the canonical name of a PrintToPDFParams setting, ignoring the case,
+// and whether there is such a setting.
+func lookupSettingKey(name string) (string, bool) {
+	t := reflect.TypeOf(page.PrintToPDFParams{})
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Replace(t.Field(i).Tag.Get("json"), ",omitempty", "", -1)
+		if strings.EqualFold(key, name) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// Validate checks the configuration and returns all the problems found.
+func (c Config) Validate() error {
+	var errs []error
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("port: %d is not a valid port", c.Port))
+	}
+	if c.Chrome.Path != "" {
+		if info, err := os.Stat(c.Chrome.Path); err != nil {
+			errs = append(errs, fmt.Errorf("chrome.path: %v", err))
+		} else if info.IsDir() {
+			errs = append(errs, fmt.Errorf("chrome.path: %s is a directory", c.Chrome.Path))
+		}
+	}
+	for _, flag := range c.Chrome.Flags {
+		if strings.TrimLeft(flag, "-") == "" {
+			errs = append(errs, fmt.Errorf("chrome.flags: empty flag"))
+		}
+	}
+	if err := validateParams(c.Defaults); err != nil {
+		errs = append(errs, fmt.Errorf("defaults: %v", err))
+	}
+	for name, profile := range c.Profiles {
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("profiles: empty profile name"))
+		}
+		if _, ok := profile["profile"]; ok {
+			errs = append(errs, fmt.Errorf("profiles.%s: a profile cannot select another profile", name))
+		}
+		if err := validateParams(profile); err != nil {
+			errs = append(errs, fmt.Errorf("profiles.%s: %v", name, err))
+		}
+	}
+	if !slices.Contains(knownPolicies, c.Sanitize.Policy) {
+		errs = append(errs, fmt.Errorf("sanitize.policy: %q is not one of %s", c.Sanitize.Policy, strings.Join(knownPolicies, ", ")))
+	}
+	// the outputs are the registered exporters
+	knownOutputs := Exporters()
+	if !slices.Contains(knownOutputs, c.Output.Default) {
+		errs = append(errs, fmt.Errorf("output.default: %q is not one of %s", c.Output.Default, strings.Join(knownOutputs, ", ")))
+	}
+	for _, output := range c.Output.Allowed {
+		if !slices.Contains(knownOutputs, output) {
+			errs = append(errs, fmt.Errorf("output.allowed: %q is not one of %s", output, strings.Join(knownOutputs, ", ")))
+		}
+	}
+	if len(c.Output.Allowed) > 0 && !slices.Contains(c.Output.Allowed, c.Output.Default) {
+		errs = append(errs, fmt.Errorf("output.default: %q is not allowed", c.Output.Default))
+	}
+	if err := validateNaming(c.Output.Naming); err != nil {
+		errs = append(errs, fmt.Errorf("output.naming: %v", err))
+	}
+	if !slices.Contains(overwritePolicies, c.Output.Overwrite) {
+		errs = append(errs, fmt.Errorf("output.overwrite: %q is not one of %s", c.Output.Overwrite, strings.Join(overwritePolicies, ", ")))
+	}
+	if c.Output.Retention < 0 {
+		errs = append(errs, fmt.Errorf("output.retention: must not be negative"))
+	}
+	if c.Output.SigningKey != "" && c.Output.URLExpiry <= 0 {
+		errs = append(errs, fmt.Errorf("output.urlExpiry: must be positive to sign URLs"))
+	}
+	for name, n := range map[string]int64{
+		"limits.maxBodyBytes": c.Limits.MaxBodyBytes,
+		"limits.maxHTMLBytes": int64(c.Limits.MaxHTMLBytes),
+		"limits.maxPages":     int64(c.Limits.MaxPages),
+		"limits.maxPDFBytes":  c.Limits.MaxPDFBytes,
+	} {
+		if n < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", name))
+		}
+	}
+	for name, d := range map[string]time.Duration{
+		"limits.readTimeout":     c.Limits.ReadTimeout,
+		"limits.writeTimeout":    c.Limits.WriteTimeout,
+		"limits.shutdownTimeout": c.Limits.ShutdownTimeout,
+		"limits.loadTimeout":     c.Limits.LoadTimeout,
+		"limits.printTimeout":    c.Limits.PrintTimeout,
+		"limits.renderTimeout":   c.Limits.RenderTimeout,
+	} {
+		if d < 0 {
+			errs = append(errs, fmt.Errorf("%s: must not be negative", name))
+		}
+	}
+	if c.Queue.MaxConcurrent < 0 {
+		errs = append(errs, fmt.Errorf("queue.maxConcurrent: must not be negative"))
+	}
+	if c.Queue.MaxWaiting < 0 {
+		errs = append(errs, fmt.Errorf("queue.maxWaiting: must not be negative"))
+	}
+	if c.Queue.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("queue.timeout: must not be negative"))
+	}
+	if c.Queue.RetryAfter < 0 {
+		errs = append(errs, fmt.Errorf("queue.retryAfter: must not be negative"))
+	}
+	// a conversion may wait in the queue, then render: the response must be written after both,
+	// or the client gets a reset connection instead of the timeout problem
+	if w, q, r := c.Limits.WriteTimeout, c.Queue.Timeout, c.Limits.RenderTimeout; w > 0 && q > 0 && r > 0 && w <= q+r {
+		errs = append(errs, fmt.Errorf("limits.writeTimeout: %s must be longer than queue.timeout plus limits.renderTimeout (%s)", w, q+r))
+	}
+	if !slices.Contains(knownCacheBackends, c.Cache.Backend) {
+		errs = append(errs, fmt.Errorf("cache.backend: %q is not one of memory, disk", c.Cache.Backend))
+	}
+	if c.Cache.Backend != "" && c.Cache.MaxBytes <= 0 {
+		errs = append(errs, fmt.Errorf("cache.maxBytes: must be positive"))
+	}
+	if c.Cache.TTL < 0 {
+		errs = append(errs, fmt.Errorf("cache.ttl: must not be negative"))
+	}
+	if c.Fonts.Upload && c.Fonts.Dir == "" {
+		errs = append(errs, fmt.Errorf("fonts.upload: requires fonts.dir"))
+	}
+	for _, token := range c.Auth.Tokens {
+		if strings.TrimSpace(token) == "" {
+			errs = append(errs, fmt.Errorf("auth.tokens: empty token"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WriteYAML writes the configuration as YAML.
+func (c Config) WriteYAML(w io.Writer) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(c)
+}
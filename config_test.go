@@ -0,0 +1,213 @@
+﻿package lazypress
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldLoadConfigFile(t *testing.T) {
+	configPath := writeTestFile(t, filepath.Join(t.TempDir(), "lazypress.yaml"), `
+port: 8080
+chrome:
+  flags: [no-sandbox, "window-size=1280,720"]
+defaults:
+  printBackground: "true"
+limits:
+  writeTimeout: 5m
+`)
+	cfg, err := LoadConfig(configPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 8080 {
+		t.Errorf("Expected port to be 8080, got %d", cfg.Port)
+	}
+	if !reflect.DeepEqual(cfg.Chrome.Flags, []string{"no-sandbox", "window-size=1280,720"}) {
+		t.Errorf("Expected chrome flags to be loaded, got %v", cfg.Chrome.Flags)
+	}
+	if cfg.Defaults["printBackground"] != "true" {
+		t.Errorf("Expected default printBackground to be true, got %v", cfg.Defaults)
+	}
+	if cfg.Limits.WriteTimeout != 5*time.Minute {
+		t.Errorf("Expected write timeout to be 5m, got %s", cfg.Limits.WriteTimeout)
+	}
+	if cfg.Limits.ReadTimeout != 30*time.Second {
+		t.Errorf("Expected read timeout to keep its default, got %s", cfg.Limits.ReadTimeout)
+	}
+}
+
+func TestShouldRejectUnknownConfigFields(t *testing.T) {
+	configPath := writeTestFile(t, filepath.Join(t.TempDir(), "lazypress.yaml"), "prot: 8080\n")
+	if _, err := LoadConfig(configPath); err == nil {
+		t.Error("Expected an error when the configuration has an unknown field")
+	}
+}
+
+func TestShouldOverrideConfigWithEnvironment(t *testing.T) {
+	cfg := DefaultConfig()
+	err := cfg.applyEnv([]string{
+		"LAZYPRESS_PORT=9000",
+		"LAZYPRESS_CHROME_FLAGS=no-sandbox disable-gpu",
+		"LAZYPRESS_SANITIZE_ALWAYS=true",
+		"LAZYPRESS_LIMITS_READ_TIMEOUT=1m",
+		"LAZYPRESS_DEFAULT_PRINTBACKGROUND=true",
+		"LAZYPRESS_AUTH_TOKENS=one two",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != 9000 {
+		t.Errorf("Expected port to be 9000, got %d", cfg.Port)
+	}
+	if !reflect.DeepEqual(cfg.Chrome.Flags, []string{"no-sandbox", "disable-gpu"}) {
+		t.Errorf("Expected chrome flags to be split, got %v", cfg.Chrome.Flags)
+	}
+	if !cfg.Sanitize.Always {
+		t.Error("Expected sanitize.always to be true")
+	}
+	if cfg.Limits.ReadTimeout != time.Minute {
+		t.Errorf("Expected read timeout to be 1m, got %s", cfg.Limits.ReadTimeout)
+	}
+	if cfg.Defaults["printBackground"] != "true" {
+		t.Errorf("Expected the default key to be printBackground, got %v", cfg.Defaults)
+	}
+	if len(cfg.Auth.Tokens) != 2 {
+		t.Errorf("Expected 2 tokens, got %v", cfg.Auth.Tokens)
+	}
+	if err := cfg.applyEnv([]string{"LAZYPRESS_PORT=abc"}); err == nil {
+		t.Error("Expected an error when an environment variable is invalid")
+	}
+}
+
+func TestShouldReportAllConfigProblems(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Port = 70000
+	cfg.Sanitize.Policy = "lax"
+	cfg.Output.Default = "s3"
+	cfg.Defaults = map[string]string{"scale": "big"}
+	cfg.Limits.LoadTimeout = -time.Second
+	cfg.Limits.MaxPages = -1
+	cfg.Queue.MaxWaiting = -1
+	cfg.Limits.WriteTimeout = cfg.Queue.Timeout + cfg.Limits.RenderTimeout
+	err := cfg.Validate()
+	if err == nil {
+		t.Fatal("Expected the configuration to be invalid")
+	}
+	for _, field := range []string{"port", "sanitize.policy", "output.default", "defaults", "limits.loadTimeout", "limits.maxPages", "queue.maxWaiting", "limits.writeTimeout"} {
+		if !strings.Contains(err.Error(), field) {
+			t.Errorf("Expected a problem with %s, got %v", field, err)
+		}
+	}
+}
+
+func newConvertRequest(t *testing.T, target, html string) *http.Request {
+	req, err := http.NewRequest("POST", target, strings.NewReader(html))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Content-Length", fmt.Sprint(len(html)))
+	return req
+}
+
+func TestShouldRequireConfiguredToken(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Auth.Tokens = []string{"secret"}
+	s, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, newConvertRequest(t, "/convert", "<html></html>"))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code to be 401, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/healthz", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected /healthz not to require a token, got %d", w.Code)
+	}
+}
+
+func TestShouldRejectOutputsNotAllowed(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Output.Allowed = []string{"download"}
+	s, err := NewServerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?output=file", "<html></html>"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400, got %d", w.Code)
+	}
+}
+
+func TestShouldMergeDefaultsWithRequestParams(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Defaults = map[string]string{"landscape": "true", "scale": "0.8"}
+	cfg.Sanitize.Always = true
+	s := newServer(cfg)
+	params, err := s.requestParams(httptest.NewRequest("POST", "/convert?scale=1.2", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"landscape": "true", "scale": "1.2", "output": "download", "sanitize": "true"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params to be %v, got %v", expected, params)
+	}
+}
+
+func TestShouldApplyTheSelectedProfile(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Defaults = map[string]string{"landscape": "true", "scale": "0.8"}
+	cfg.Profiles = map[string]map[string]string{
+		"invoice-a4": {"paperWidth": "8.27", "paperHeight": "11.69", "scale": "0.9"},
+	}
+	s := newServer(cfg)
+	params, err := s.requestParams(httptest.NewRequest("POST", "/convert?profile=invoice-a4&paperHeight=12", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"landscape": "true", "scale": "0.9", "paperWidth": "8.27", "paperHeight": "12", "output": "download"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params to be %v, got %v", expected, params)
+	}
+}
+
+func TestShouldRejectUnknownProfiles(t *testing.T) {
+	s := newServer(DefaultConfig())
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?profile=missing", "<html></html>"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400, got %d", w.Code)
+	}
+}
+
+func TestShouldRejectInvalidProfiles(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Profiles = map[string]map[string]string{"broken": {"scale": "big"}}
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "profiles.broken") {
+		t.Errorf("Expected an error about profiles.broken, got %v", err)
+	}
+}
+
+func TestShouldRejectBodiesOverTheLimit(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Limits.MaxBodyBytes = 10
+	s := newServer(cfg)
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code to be 413, got %d", w.Code)
+	}
+}
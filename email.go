@@ -0,0 +1,285 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+)
+
+// EmailContentType is the content type of the emails.
+const EmailContentType = "message/rfc822"
+
+// emailHeaders are the headers of an email shown above its body.
+var emailHeaders = []string{"From", "To", "Cc", "Date", "Subject"}
+
+// emailCSS is the stylesheet of the headers of the emails, and of their images that are not part of the body.
+const emailCSS = `.email-headers { margin: 0 0 1.5em; padding-bottom: 0.75em; border-bottom: 1px solid #ccc; font: 10pt/1.4 -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; color: #222; }
+.email-headers table { border-collapse: collapse; }
+.email-headers th { padding: 0.1em 1em 0.1em 0; text-align: left; vertical-align: top; font-weight: normal; color: #666; white-space: nowrap; }
+.email-headers td { padding: 0.1em 0; overflow-wrap: anywhere; }
+.email-headers .subject td { font-weight: bold; }
+.email-images img { display: block; max-width: 100%; margin-top: 1em; }
+`
+
+// maxMIMEDepth is how deep the multipart entities of a message can be nested.
+const maxMIMEDepth = 10
+
+// headerDecoder decodes the encoded words of the headers, e.g. =?ISO-8859-1?Q?caf=E9?=.
+var headerDecoder = &mime.WordDecoder{CharsetReader: charset.NewReaderLabel}
+
+// RenderEmail renders an email (RFC 5322, with its MIME parts) to a standalone HTML document: its headers, followed by
+// its HTML body or else its text body. The inline images are embedded, and the attachments are listed with the headers.
+func RenderEmail(message []byte) ([]byte, error) {
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("invalid email: %v", err)}
+	}
+	parts, err := readMIMEParts(textproto.MIMEHeader(msg.Header), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var htmlBody, textBody *mimePart
+	var attachments []string
+	var inlineImages []mimePart
+	resources := map[string]string{}
+	for i, part := range parts {
+		disposition, name := part.disposition()
+		switch {
+		case disposition == "attachment":
+			attachments = append(attachments, cmp.Or(name, part.mediaType))
+		case part.mediaType == "text/html" && htmlBody == nil:
+			htmlBody = &parts[i]
+		case part.mediaType == "text/plain" && textBody == nil:
+			textBody = &parts[i]
+		case strings.HasPrefix(part.mediaType, "image/"):
+			inlineImages = append(inlineImages, part)
+			if id := part.contentID(); id != "" {
+				resources["cid:"+id] = dataURL(part.mediaType, part.content)
+			}
+		case name != "":
+			attachments = append(attachments, name)
+		}
+	}
+
+	var body bytes.Buffer
+	switch {
+	case htmlBody != nil:
+		body.Write(htmlBody.content)
+	case textBody != nil:
+		body.WriteString("<!DOCTYPE html><html><head></head><body>")
+		writePlainText(&body, textBody.content, TextOptions{})
+		body.WriteString("</body></html>")
+	}
+	doc, err := html.Parse(&body)
+	if err != nil {
+		return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("invalid HTML body: %v", err)}
+	}
+	referenced := map[string]bool{}
+	rewriteNode(doc, "", func(ref, _ string) (string, bool) {
+		ref = strings.TrimSpace(ref)
+		if unescaped, err := url.PathUnescape(ref); err == nil {
+			ref = unescaped
+		}
+		referenced[ref] = true
+		dataURL, ok := resources[ref]
+		return dataURL, ok
+	})
+
+	var head strings.Builder
+	head.WriteString(`<meta charset="utf-8">`)
+	if subject := decodeHeader(msg.Header.Get("Subject")); subject != "" {
+		fmt.Fprintf(&head, "<title>%s</title>", html.EscapeString(subject))
+	}
+	if from := decodeHeader(msg.Header.Get("From")); from != "" {
+		fmt.Fprintf(&head, `<meta name="author" content="%s">`, html.EscapeString(from))
+	}
+	head.WriteString("<style>" + emailCSS)
+	if htmlBody == nil {
+		head.WriteString(plainTextCSS)
+	}
+	head.WriteString("</style>")
+
+	var headers strings.Builder
+	headers.WriteString(`<header class="email-headers"><table>`)
+	for _, name := range emailHeaders {
+		if value := decodeHeader(msg.Header.Get(name)); value != "" {
+			fmt.Fprintf(&headers, `<tr class="%s"><th>%s</th><td>%s</td></tr>`, strings.ToLower(name), name, html.EscapeString(value))
+		}
+	}
+	if len(attachments) > 0 {
+		fmt.Fprintf(&headers, `<tr class="attachments"><th>Attachments</th><td>%s</td></tr>`, html.EscapeString(strings.Join(attachments, ", ")))
+	}
+	headers.WriteString("</table></header>")
+
+	// the images that the body does not show, e.g. the photos sent with a text email, follow it
+	var images strings.Builder
+	for _, image := range inlineImages {
+		if id := image.contentID(); id != "" && referenced["cid:"+id] {
+			continue
+		}
+		if images.Len() == 0 {
+			images.WriteString(`<div class="email-images">`)
+		}
+		fmt.Fprintf(&images, `<img src="%s">`, dataURL(image.mediaType, image.content))
+	}
+	if images.Len() > 0 {
+		images.WriteString("</div>")
+	}
+
+	headNode, bodyNode := findElement(doc, "head"), findElement(doc, "body")
+	if err := insertHTML(headNode, head.String(), true); err != nil {
+		return nil, err
+	}
+	if err := insertHTML(bodyNode, headers.String(), true); err != nil {
+		return nil, err
+	}
+	if err := insertHTML(bodyNode, images.String(), false); err != nil {
+		return nil, err
+	}
+	var out bytes.Buffer
+	if err := html.Render(&out, doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// mimePart is a leaf of a MIME message, with its content decoded: text parts are converted to UTF-8.
+type mimePart struct {
+	header    textproto.MIMEHeader
+	mediaType string
+	params    map[string]string
+	content   []byte
+}
+
+// contentID returns the Content-ID of the part, without its angle brackets.
+func (part mimePart) contentID() string {
+	return strings.Trim(part.header.Get("Content-ID"), "<> ")
+}
+
+// disposition returns the disposition of the part, e.g. attachment, and its file name.
+func (part mimePart) disposition() (string, string) {
+	disposition, params, _ := mime.ParseMediaType(part.header.Get("Content-Disposition"))
+	return strings.ToLower(disposition), decodeHeader(cmp.Or(params["filename"], part.params["name"]))
+}
+
+// readMIMEParts reads the leaves of a MIME entity, walking down its multipart entities.
+// A malformed entity is reported as a *ParamError.
+func readMIMEParts(header textproto.MIMEHeader, body io.Reader) ([]mimePart, error) {
+	return appendMIMEParts(nil, header, body, 0)
+}
+
+func appendMIMEParts(parts []mimePart, header textproto.MIMEHeader, body io.Reader, depth int) ([]mimePart, error) {
+	mediaType, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	if mediaType == "" {
+		mediaType, params = "text/plain", map[string]string{}
+	}
+	if strings.HasPrefix(mediaType, "multipart/") {
+		if params["boundary"] == "" {
+			return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("the %s entity has no boundary", mediaType)}
+		}
+		if depth >= maxMIMEDepth {
+			return nil, &ParamError{Name: "body", Reason: "the multipart entities are nested too deep"}
+		}
+		reader := multipart.NewReader(body, params["boundary"])
+		for {
+			// the raw parts keep their Content-Transfer-Encoding, which is decoded below like the one of the message
+			part, err := reader.NextRawPart()
+			if errors.Is(err, io.EOF) {
+				return parts, nil
+			}
+			if err != nil {
+				return nil, &ParamError{Name: "body", Reason: err.Error()}
+			}
+			if parts, err = appendMIMEParts(parts, part.Header, part, depth+1); err != nil {
+				return nil, err
+			}
+		}
+	}
+	content, err := io.ReadAll(decodeTransferEncoding(header.Get("Content-Transfer-Encoding"), body))
+	if err != nil {
+		return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("could not decode a %s part: %v", mediaType, err)}
+	}
+	if strings.HasPrefix(mediaType, "text/") {
+		content = decodeCharset(content, params["charset"])
+	}
+	return append(parts, mimePart{header: header, mediaType: mediaType, params: params, content: content}), nil
+}
+
+// decodeTransferEncoding decodes a base64 or quoted-printable body. The other encodings leave the body as it is.
+func decodeTransferEncoding(encoding string, body io.Reader) io.Reader {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "base64":
+		return base64.NewDecoder(base64.StdEncoding, body)
+	case "quoted-printable":
+		return quotedprintable.NewReader(body)
+	}
+	return body
+}
+
+// decodeCharset converts text in the charset to UTF-8. The text is left as it is when the charset is unknown.
+func decodeCharset(content []byte, label string) []byte {
+	switch strings.ToLower(label) {
+	case "", "utf-8", "utf8", "us-ascii":
+		return content
+	}
+	reader, err := charset.NewReaderLabel(label, bytes.NewReader(content))
+	if err != nil {
+		return content
+	}
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return content
+	}
+	return decoded
+}
+
+// decodeHeader decodes the encoded words of a header value, or returns it as it is when they cannot be decoded.
+func decodeHeader(value string) string {
+	if decoded, err := headerDecoder.DecodeHeader(value); err == nil {
+		value = decoded
+	}
+	return strings.TrimSpace(value)
+}
+
+// findElement returns the first element with the tag in the tree of the node, or nil.
+func findElement(n *html.Node, tag string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == tag {
+		return n
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if found := findElement(child, tag); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// insertHTML parses the HTML fragment in the context of the parent element and inserts it as its first or last children.
+func insertHTML(parent *html.Node, fragment string, first bool) error {
+	nodes, err := html.ParseFragment(strings.NewReader(fragment), parent)
+	if err != nil {
+		return err
+	}
+	var before *html.Node
+	if first {
+		before = parent.FirstChild
+	}
+	for _, node := range nodes {
+		parent.InsertBefore(node, before)
+	}
+	return nil
+}
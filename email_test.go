@@ -0,0 +1,93 @@
+﻿package lazypress
+
+import (
+	"strings"
+	"testing"
+)
+
+const testEmail = "From: Jane Doe <jane@example.com>\r\n" +
+	"To: John <john@example.com>\r\n" +
+	"Subject: =?ISO-8859-1?Q?Caf=E9_menu?=\r\n" +
+	"Date: Mon, 4 Mar 2024 10:00:00 +0100\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/mixed; boundary=mixed\r\n" +
+	"\r\n" +
+	"--mixed\r\n" +
+	"Content-Type: multipart/related; boundary=related\r\n" +
+	"\r\n" +
+	"--related\r\n" +
+	"Content-Type: text/html; charset=iso-8859-1\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"\r\n" +
+	"<html><head><style>p { color: red }</style></head><body><p>Caf=E9</p><img src=3D\"cid:logo@example\"></body></html>\r\n" +
+	"--related\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@example>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"--related--\r\n" +
+	"--mixed\r\n" +
+	"Content-Type: application/pdf; name=\"menu.pdf\"\r\n" +
+	"Content-Disposition: attachment; filename=\"menu.pdf\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"JVBERi0=\r\n" +
+	"--mixed--\r\n"
+
+func TestShouldRenderEmails(t *testing.T) {
+	content, err := RenderEmail([]byte(testEmail))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+	for _, expected := range []string{
+		"<title>Café menu</title>",
+		`<meta name="author" content="Jane Doe &lt;jane@example.com&gt;"/>`,
+		`<tr class="from"><th>From</th><td>Jane Doe &lt;jane@example.com&gt;</td></tr>`,
+		`<tr class="subject"><th>Subject</th><td>Café menu</td></tr>`,
+		`<tr class="attachments"><th>Attachments</th><td>menu.pdf</td></tr>`,
+		"<style>p { color: red }</style>",
+		"<p>Café</p>",
+		`<img src="data:image/png;base64,iVBORw0KGgo="/>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML to contain %q, got %s", expected, html)
+		}
+	}
+	if strings.Contains(html, `class="email-images"`) {
+		t.Errorf("Expected the image of the body not to be repeated, got %s", html)
+	}
+	if metadata := DocumentMetadata(content); metadata["title"] != "Café menu" {
+		t.Errorf("Expected the subject to be the title, got %v", metadata)
+	}
+}
+
+func TestShouldRenderTextEmailsWithTheirImages(t *testing.T) {
+	content, err := RenderEmail([]byte("Subject: Photos\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee <below>\r\n" +
+		"--b\r\nContent-Type: image/jpeg\r\nContent-Disposition: inline; filename=photo.jpg\r\nContent-Transfer-Encoding: base64\r\n\r\n/9j/\r\n" +
+		"--b--\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+	for _, expected := range []string{
+		`<span class="line">See &lt;below&gt;`,
+		`<div class="email-images"><img src="data:image/jpeg;base64,/9j/"/></div>`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML to contain %q, got %s", expected, html)
+		}
+	}
+
+	for _, message := range []string{
+		"not an email",
+		"Subject: Hello\r\nContent-Type: multipart/mixed\r\n\r\nHello",
+	} {
+		if _, err := RenderEmail([]byte(message)); len(paramErrors(err)) != 1 {
+			t.Errorf("Expected %q to be an invalid email, got %v", message, err)
+		}
+	}
+}
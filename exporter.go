@@ -0,0 +1,153 @@
+﻿package lazypress
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// PDFContentType is the content type of the PDFs.
+const PDFContentType = "application/pdf"
+
+// Exporter sends PDFs to a destination, such as the HTTP response or a file.
+// Exporters are registered by name with RegisterExporter, and chosen with the output setting (see LoadSettings).
+type Exporter interface {
+	// Open starts the export of a PDF. The PDF is written to the returned Export, which is then closed.
+	Open(meta ExportMetadata) (Export, error)
+}
+
+// Export is a PDF being exported.
+type Export interface {
+	io.WriteCloser
+	// Result describes where the PDF went. It is called once the Export is closed.
+	Result() ExportResult
+}
+
+// Aborter is implemented by the Exports that can discard a PDF that was not completely exported,
+// e.g. by removing its file (see PDF.AbortExport).
+type Aborter interface {
+	Abort() error
+}
+
+// ExportMetadata describes the PDF given to an Exporter.
+type ExportMetadata struct {
+	// Filename is the name requested with the filename setting. It may be empty.
+	Filename    string
+	ContentType string
+	RequestID   string
+	// Writer is the writer given to LoadSettings, e.g. the HTTP response. It may be nil.
+	Writer io.Writer
+}
+
+// ExportResult describes where an Exporter put a PDF.
+// The server sends it back as JSON, unless the PDF is the response itself.
+type ExportResult struct {
+	// Output is the name of the Exporter.
+	Output string `json:"output"`
+	// ID identifies the PDF in the destination, e.g. a file name or a document ID.
+	ID string `json:"id,omitempty"`
+	// Location is where to find the PDF, e.g. a path or a URL.
+	Location    string `json:"location,omitempty"`
+	Bytes       int64  `json:"bytes"`
+	ContentType string `json:"contentType"`
+	RequestID   string `json:"requestId,omitempty"`
+	// Inline reports that the PDF was written to the Writer of the ExportMetadata,
+	// so there is nothing else to send back.
+	Inline bool `json:"-"`
+}
+
+var (
+	exportersMu sync.RWMutex
+	exporters   = map[string]Exporter{}
+)
+
+func init() {
+	RegisterExporter("download", ExporterFunc(openDownload))
+	RegisterExporter("file", &FileExporter{})
+}
+
+// RegisterExporter makes an Exporter available under the given name, which is case insensitive.
+// Like database/sql.Register, it panics if the exporter is nil or the name is already registered.
+func RegisterExporter(name string, exporter Exporter) {
+	name = strings.ToLower(name)
+	exportersMu.Lock()
+	defer exportersMu.Unlock()
+	if exporter == nil {
+		panic("lazypress: RegisterExporter exporter is nil")
+	}
+	if _, dup := exporters[name]; dup {
+		panic("lazypress: RegisterExporter called twice for exporter " + name)
+	}
+	exporters[name] = exporter
+}
+
+// Exporters returns the sorted names of the registered exporters.
+func Exporters() []string {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupExporter(name string) (Exporter, bool) {
+	exportersMu.RLock()
+	defer exportersMu.RUnlock()
+	exporter, ok := exporters[strings.ToLower(name)]
+	return exporter, ok
+}
+
+// ExporterFunc adapts a function to the Exporter interface.
+type ExporterFunc func(meta ExportMetadata) (Export, error)
+
+// Open calls f(meta).
+func (f ExporterFunc) Open(meta ExportMetadata) (Export, error) {
+	return f(meta)
+}
+
+// writerExport is an Export to an io.Writer, counting the bytes written.
+type writerExport struct {
+	w      io.Writer
+	close  func() error
+	result ExportResult
+}
+
+func (e *writerExport) Write(b []byte) (int, error) {
+	n, err := e.w.Write(b)
+	e.result.Bytes += int64(n)
+	return n, err
+}
+
+func (e *writerExport) Close() error {
+	if e.close == nil {
+		return nil
+	}
+	return e.close()
+}
+
+func (e *writerExport) Result() ExportResult {
+	return e.result
+}
+
+func newWriterExport(output string, w io.Writer, close func() error, meta ExportMetadata) *writerExport {
+	return &writerExport{w: w, close: close, result: ExportResult{
+		Output:      output,
+		ContentType: meta.ContentType,
+		RequestID:   meta.RequestID,
+	}}
+}
+
+// openDownload exports the PDF to the Writer of the metadata, i.e. the HTTP response for the server.
+func openDownload(meta ExportMetadata) (Export, error) {
+	if meta.Writer == nil {
+		return nil, fmt.Errorf("nothing to download the PDF to")
+	}
+	e := newWriterExport("download", meta.Writer, nil, meta)
+	e.result.Inline = true
+	return e, nil
+}
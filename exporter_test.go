@@ -0,0 +1,70 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"slices"
+	"testing"
+)
+
+type memoryStore struct {
+	docs map[string][]byte
+}
+
+func (s *memoryStore) Open(meta ExportMetadata) (Export, error) {
+	var buf bytes.Buffer
+	e := newWriterExport("memory", &buf, func() error {
+		s.docs[meta.Filename] = buf.Bytes()
+		return nil
+	}, meta)
+	e.result.ID = meta.Filename
+	return e, nil
+}
+
+func TestShouldExportWithRegisteredExporter(t *testing.T) {
+	store := &memoryStore{docs: map[string][]byte{}}
+	RegisterExporter("Memory", store)
+	t.Cleanup(func() {
+		exportersMu.Lock()
+		delete(exporters, "memory")
+		exportersMu.Unlock()
+	})
+	if !slices.Contains(Exporters(), "memory") {
+		t.Errorf("Expected memory to be registered, got %v", Exporters())
+	}
+
+	p := PDF{RequestID: "abc"}
+	if err := p.LoadSettings(map[string]string{"output": "memory", "filename": "report"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Content = []byte("%PDF-1.4")
+	result, err := p.ExportWithResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(store.docs["report"]) != "%PDF-1.4" {
+		t.Errorf("Expected the PDF to be stored, got %v", store.docs)
+	}
+	expected := ExportResult{Output: "memory", ID: "report", Bytes: 8, ContentType: PDFContentType, RequestID: "abc"}
+	if *result != expected {
+		t.Errorf("Expected the result to be %+v, got %+v", expected, *result)
+	}
+}
+
+func TestShouldPanicWhenExporterIsRegisteredTwice(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected RegisterExporter to panic")
+		}
+	}()
+	RegisterExporter("download", ExporterFunc(openDownload))
+}
+
+func TestShouldRejectUnknownOutputs(t *testing.T) {
+	var p PDF
+	err := p.LoadSettings(map[string]string{"output": "s3"}, &mockWriter{}, nil)
+	errs := paramErrors(err)
+	if len(errs) != 1 || errs[0].Name != "output" {
+		t.Errorf("Expected output to be invalid, got %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+﻿package lazypress
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Overwrite policies of a FileExporter, used when a file with the same name already exists.
+const (
+	// OverwriteRename saves the PDF under a new name, adding -1, -2, etc. to the name.
+	OverwriteRename = "rename"
+	// OverwriteReplace replaces the existing file.
+	OverwriteReplace = "replace"
+	// OverwriteError fails the export.
+	OverwriteError = "error"
+)
+
+// DefaultNaming is the naming template used when a FileExporter has none.
+const DefaultNaming = "{filename}-{uuid}"
+
+var overwritePolicies = []string{OverwriteRename, OverwriteReplace, OverwriteError}
+
+// namingPlaceholder matches the placeholders of a naming template, e.g. {date}.
+var namingPlaceholder = regexp.MustCompile(`\{([a-zA-Z]+)\}`)
+
+// unsafeFilenameChars matches what is replaced in the names coming from the requests.
+var unsafeFilenameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// FileExporter saves the PDFs as files under Dir.
+// The results identify the files by their path relative to Dir, so the path of the server is never disclosed.
+type FileExporter struct {
+	// Dir is the root of the saved files. It defaults to a lazypress directory in the home directory
+	// (or in the temporary directory if there is no home).
+	Dir string
+	// Naming is the template of the file names, relative to Dir. It can contain slashes to create subdirectories, and the placeholders:
+	//	{filename}  the filename requested by the client, sanitized (lazypress if there is none)
+	//	{requestId} the request ID
+	//	{uuid}      a random UUID
+	//	{date}      the date, as 2006-01-02
+	//	{time}      the time, as 150405
+	//	{timestamp} the Unix time, in seconds
+	// The .pdf extension is added if it is missing. It defaults to DefaultNaming.
+	Naming string
+	// Overwrite is the policy when a file already exists: OverwriteRename (the default), OverwriteReplace or OverwriteError.
+	Overwrite string
+	// Retention is how long the files are kept by Cleanup. 0 keeps them forever.
+	Retention time.Duration
+}
+
+// validateNaming checks the placeholders of a naming template.
+func validateNaming(naming string) error {
+	for _, match := range namingPlaceholder.FindAllStringSubmatch(naming, -1) {
+		switch match[1] {
+		case "filename", "requestId", "uuid", "date", "time", "timestamp":
+		default:
+			return fmt.Errorf("unknown placeholder %s", match[0])
+		}
+	}
+	return nil
+}
+
+// Root returns the directory of the files.
+func (e *FileExporter) Root() string {
+	if e.Dir != "" {
+		return e.Dir
+	}
+	dir, err := os.UserHomeDir()
+	if err != nil {
+		// falback to tmp dir
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "lazypress")
+}
+
+// Open creates the file of the PDF.
+func (e *FileExporter) Open(meta ExportMetadata) (Export, error) {
+	name, err := e.fileName(meta, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	file, id, err := e.create(name)
+	if err != nil {
+		return nil, err
+	}
+	export := &fileExport{writerExport: newWriterExport("file", file, file.Close, meta), path: file.Name()}
+	export.result.ID = id
+	return export, nil
+}
+
+// fileExport is the Export of a FileExporter. Abort removes its file.
+type fileExport struct {
+	*writerExport
+	path string
+}
+
+func (e *fileExport) Abort() error {
+	e.Close()
+	return os.Remove(e.path)
+}
+
+// fileName returns the name of the file of the PDF, relative to the root, using forward slashes.
+func (e *FileExporter) fileName(meta ExportMetadata, now time.Time) (string, error) {
+	naming := e.Naming
+	if naming == "" {
+		naming = DefaultNaming
+	}
+	filename := sanitizeFilename(strings.TrimSuffix(meta.Filename, ".pdf"))
+	if filename == "" {
+		filename = "lazypress"
+	}
+	var err error
+	name := namingPlaceholder.ReplaceAllStringFunc(naming, func(placeholder string) string {
+		switch placeholder {
+		case "{filename}":
+			return filename
+		case "{requestId}":
+			return sanitizeFilename(meta.RequestID)
+		case "{uuid}":
+			return newUUID()
+		case "{date}":
+			return now.Format("2006-01-02")
+		case "{time}":
+			return now.Format("150405")
+		case "{timestamp}":
+			return strconv.FormatInt(now.Unix(), 10)
+		}
+		err = fmt.Errorf("unknown placeholder %s in naming template", placeholder)
+		return placeholder
+	})
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+		name += ".pdf"
+	}
+	name = path.Clean("/" + filepath.ToSlash(name))[1:]
+	if !fs.ValidPath(name) || name == "." {
+		return "", fmt.Errorf("invalid file name %q", name)
+	}
+	return name, nil
+}
+
+// create creates the file following the overwrite policy, and returns it with its ID.
+func (e *FileExporter) create(name string) (*os.File, string, error) {
+	root := e.Root()
+	if err := os.MkdirAll(filepath.Join(root, filepath.Dir(filepath.FromSlash(name))), 0o755); err != nil {
+		return nil, "", err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if e.Overwrite == OverwriteReplace {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	id := name
+	for i := 1; ; i++ {
+		file, err := os.OpenFile(filepath.Join(root, filepath.FromSlash(id)), flags, 0o644)
+		if err == nil {
+			return file, id, nil
+		}
+		if !errors.Is(err, fs.ErrExist) || e.Overwrite == OverwriteError {
+			return nil, "", err
+		}
+		if i > 1000 {
+			return nil, "", fmt.Errorf("could not find a free name for %s", name)
+		}
+		id = fmt.Sprintf("%s-%d.pdf", strings.TrimSuffix(name, ".pdf"), i)
+	}
+}
+
+// Path returns the path of the PDF with the given ID, making sure it is under the root.
+func (e *FileExporter) Path(id string) (string, error) {
+	if !fs.ValidPath(id) || id == "." || !strings.HasSuffix(strings.ToLower(id), ".pdf") {
+		return "", fmt.Errorf("invalid file ID %q", id)
+	}
+	return filepath.Join(e.Root(), filepath.FromSlash(id)), nil
+}
+
+// StoredFile describes a PDF saved by a FileExporter.
+type StoredFile struct {
+	ID       string    `json:"id"`
+	Bytes    int64     `json:"bytes"`
+	Modified time.Time `json:"modified"`
+}
+
+// List returns the PDFs under the root, the most recent first.
+func (e *FileExporter) List() ([]StoredFile, error) {
+	root := e.Root()
+	files := []StoredFile{}
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, StoredFile{ID: filepath.ToSlash(rel), Bytes: info.Size(), Modified: info.ModTime()})
+		return nil
+	})
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].Modified.After(files[j].Modified)
+	})
+	return files, err
+}
+
+// Cleanup removes the PDFs older than the retention, as well as the directories it leaves empty.
+// It returns the number of files removed.
+func (e *FileExporter) Cleanup(now time.Time) (int, error) {
+	if e.Retention <= 0 {
+		return 0, nil
+	}
+	root := e.Root()
+	removed := 0
+	var dirs []string
+	err := filepath.WalkDir(root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if name != root {
+				dirs = append(dirs, name)
+			}
+			return nil
+		}
+		if !strings.HasSuffix(strings.ToLower(name), ".pdf") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if now.Sub(info.ModTime()) > e.Retention {
+			if err := os.Remove(name); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	// the deepest directories come last
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // fails if the directory is not empty
+	}
+	return removed, err
+}
+
+// runCleanup calls Cleanup periodically until ctx is done.
+func (e *FileExporter) runCleanup(ctx context.Context) {
+	if e.Retention <= 0 {
+		return
+	}
+	interval := min(max(e.Retention/10, time.Minute), time.Hour)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if removed, err := e.Cleanup(time.Now()); err != nil {
+			getLogger().Warn("could not clean up files", "error", err)
+		} else if removed > 0 {
+			getLogger().Info("files cleaned up", "removed", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sanitizeFilename keeps only letters, digits, dots, dashes and underscores,
+// so that a name coming from a request cannot escape the output directory.
+func sanitizeFilename(name string) string {
+	name = unsafeFilenameChars.ReplaceAllString(name, "_")
+	name = strings.TrimLeft(name, ".")
+	if len(name) > 100 {
+		name = name[:100]
+	}
+	return name
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
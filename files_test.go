@@ -0,0 +1,121 @@
+﻿package lazypress
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldSanitizeRequestedFilenames(t *testing.T) {
+	e := &FileExporter{Dir: t.TempDir(), Naming: "{filename}"}
+	for filename, expected := range map[string]string{
+		"report":            "report.pdf",
+		"report.pdf":        "report.pdf",
+		"../../etc/passwd":  "_.._etc_passwd.pdf",
+		"..":                "lazypress.pdf",
+		"/absolute/path":    "_absolute_path.pdf",
+		"dir\\file":         "dir_file.pdf",
+		"invoice 2024 (v2)": "invoice_2024_v2_.pdf",
+	} {
+		name, err := e.fileName(ExportMetadata{Filename: filename}, time.Now())
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", filename, err)
+		}
+		if name != expected {
+			t.Errorf("Expected %q to be saved as %q, got %q", filename, expected, name)
+		}
+	}
+}
+
+func TestShouldNameFilesWithTemplate(t *testing.T) {
+	e := &FileExporter{Naming: "{date}/{requestId}-{filename}-{time}"}
+	now := time.Date(2024, 3, 1, 13, 4, 5, 0, time.UTC)
+	name, err := e.fileName(ExportMetadata{Filename: "invoice", RequestID: "abc"}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "2024-03-01/abc-invoice-130405.pdf" {
+		t.Errorf("Expected the name to follow the template, got %q", name)
+	}
+
+	name, err = (&FileExporter{}).fileName(ExportMetadata{}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(name) != len("lazypress-00000000-0000-0000-0000-000000000000.pdf") {
+		t.Errorf("Expected the default name to have a UUID, got %q", name)
+	}
+}
+
+func TestShouldKeepTemplatesInsideTheRoot(t *testing.T) {
+	name, err := (&FileExporter{Naming: "../../{filename}"}).fileName(ExportMetadata{Filename: "x"}, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "x.pdf" {
+		t.Errorf("Expected the name to stay inside the root, got %q", name)
+	}
+	if err := validateNaming("{filename}-{nope}"); err == nil {
+		t.Error("Expected an error for an unknown placeholder")
+	}
+}
+
+func TestShouldApplyOverwritePolicies(t *testing.T) {
+	dir := t.TempDir()
+	write := func(overwrite, content string) (string, error) {
+		e := &FileExporter{Dir: dir, Naming: "report", Overwrite: overwrite}
+		export, err := e.Open(ExportMetadata{})
+		if err != nil {
+			return "", err
+		}
+		export.Write([]byte(content))
+		export.Close()
+		return export.Result().ID, nil
+	}
+
+	if id, err := write(OverwriteRename, "first"); err != nil || id != "report.pdf" {
+		t.Errorf("Expected report.pdf, got %q (%v)", id, err)
+	}
+	if id, err := write(OverwriteRename, "second"); err != nil || id != "report-1.pdf" {
+		t.Errorf("Expected report-1.pdf, got %q (%v)", id, err)
+	}
+	if _, err := write(OverwriteError, "third"); err == nil {
+		t.Error("Expected an error when the file exists")
+	}
+	if id, err := write(OverwriteReplace, "fourth"); err != nil || id != "report.pdf" {
+		t.Errorf("Expected report.pdf, got %q (%v)", id, err)
+	}
+	content, _ := os.ReadFile(filepath.Join(dir, "report.pdf"))
+	if string(content) != "fourth" {
+		t.Errorf("Expected the file to be replaced, got %q", content)
+	}
+}
+
+func TestShouldCleanUpOldFiles(t *testing.T) {
+	dir := t.TempDir()
+	e := &FileExporter{Dir: dir, Retention: time.Hour}
+	old := writeTestFile(t, filepath.Join(dir, "2024-01-01", "old.pdf"), "%PDF")
+	recent := writeTestFile(t, filepath.Join(dir, "recent.pdf"), "%PDF")
+	other := writeTestFile(t, filepath.Join(dir, "notes.txt"), "keep me")
+	past := time.Now().Add(-2 * time.Hour)
+	for _, name := range []string{old, other} {
+		if err := os.Chtimes(name, past, past); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := e.Cleanup(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 1 {
+		t.Errorf("Expected 1 file to be removed, got %d", removed)
+	}
+	for name, expected := range map[string]bool{old: false, recent: true, other: true, filepath.Dir(old): false} {
+		if _, err := os.Stat(name); (err == nil) != expected {
+			t.Errorf("Expected %s to exist: %v", strings.TrimPrefix(name, dir), expected)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+﻿package lazypress
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// fileURL returns the URL to download the file with the given ID.
+// When a signing key is configured, the URL is signed, so that it can be used without a token until it expires.
+func (s *Server) fileURL(id string, now time.Time) string {
+	u := url.URL{Path: "/files/" + id}
+	if s.config.Output.SigningKey != "" {
+		expires := strconv.FormatInt(now.Add(s.config.Output.URLExpiry).Unix(), 10)
+		u.RawQuery = url.Values{
+			"expires":   {expires},
+			"signature": {s.fileSignature(id, expires)},
+		}.Encode()
+	}
+	return u.String()
+}
+
+func (s *Server) fileSignature(id, expires string) string {
+	mac := hmac.New(sha256.New, []byte(s.config.Output.SigningKey))
+	mac.Write([]byte(id + "\n" + expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// validFileSignature reports whether the request has a valid signature for the file, which has not expired.
+func (s *Server) validFileSignature(r *http.Request, id string, now time.Time) bool {
+	if s.config.Output.SigningKey == "" {
+		return false
+	}
+	expires := r.URL.Query().Get("expires")
+	signature, err := hex.DecodeString(r.URL.Query().Get("signature"))
+	if err != nil || expires == "" {
+		return false
+	}
+	expiresAt, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || now.Unix() > expiresAt {
+		return false
+	}
+	expected, _ := hex.DecodeString(s.fileSignature(id, expires))
+	return hmac.Equal(signature, expected)
+}
+
+// handleGetFile downloads a PDF saved by the file output.
+// It requires a token, unless the URL has a valid signature.
+func (s *Server) handleGetFile(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if s.validFileSignature(r, id, time.Now()) {
+		s.serveFile(w, r, id)
+		return
+	}
+	s.requireAuth(func(w http.ResponseWriter, r *http.Request) {
+		s.serveFile(w, r, id)
+	})(w, r)
+}
+
+func (s *Server) serveFile(w http.ResponseWriter, r *http.Request, id string) {
+	name, err := s.files.Path(id)
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "The file does not exist.", nil)
+		return
+	}
+	file, err := os.Open(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeProblem(w, r, http.StatusNotFound, "The file does not exist.", nil)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		writeProblem(w, r, http.StatusNotFound, "The file does not exist.", nil)
+		return
+	}
+	w.Header().Set("Content-Type", PDFContentType)
+	// the names come from the naming template, so they are quoted, or encoded when they are not ASCII
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(id)}))
+	http.ServeContent(w, r, path.Base(id), info.ModTime(), file)
+}
+
+// handleDeleteFile deletes a PDF saved by the file output.
+func (s *Server) handleDeleteFile(w http.ResponseWriter, r *http.Request) {
+	name, err := s.files.Path(r.PathValue("id"))
+	if err != nil {
+		writeProblem(w, r, http.StatusNotFound, "The file does not exist.", nil)
+		return
+	}
+	if err := os.Remove(name); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeProblem(w, r, http.StatusNotFound, "The file does not exist.", nil)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	loggerWithRequestID(requestIDFromRequest(r)).Info("file deleted", "id", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListFiles lists the PDFs saved by the file output, the most recent first.
+func (s *Server) handleListFiles(w http.ResponseWriter, r *http.Request) {
+	files, err := s.files.List()
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	type listedFile struct {
+		StoredFile
+		URL string `json:"url"`
+	}
+	now := time.Now()
+	listed := make([]listedFile, len(files))
+	for i, file := range files {
+		listed[i] = listedFile{StoredFile: file, URL: s.fileURL(file.ID, now)}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"files": listed})
+}
@@ -0,0 +1,175 @@
+﻿package lazypress
+
+import (
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func newFileServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	cfg := DefaultConfig()
+	cfg.Output.Dir = t.TempDir()
+	cfg.Output.SigningKey = "secret"
+	cfg.Auth.Tokens = []string{"token"}
+	s := newServer(cfg)
+	writeTestFile(t, filepath.Join(cfg.Output.Dir, "2024-03-01", "invoice.pdf"), "%PDF-1.4")
+	return s, cfg.Output.Dir
+}
+
+func TestShouldEncodeTheNamesOfDownloadedFiles(t *testing.T) {
+	s, dir := newFileServer(t)
+	for _, name := range []string{`quote"semi;colon.pdf`, "facture-é.pdf"} {
+		writeTestFile(t, filepath.Join(dir, name), "%PDF-1.4")
+		r := httptest.NewRequest("GET", "/files/"+url.PathEscape(name), nil)
+		r.Header.Set("Authorization", "Bearer token")
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, r)
+		disposition, params, err := mime.ParseMediaType(w.Header().Get("Content-Disposition"))
+		if err != nil || disposition != "attachment" || params["filename"] != name {
+			t.Errorf("Expected the attachment to be named %q, got %q (%v)", name, w.Header().Get("Content-Disposition"), err)
+		}
+	}
+}
+
+func TestShouldDownloadStoredFileWithToken(t *testing.T) {
+	s, _ := newFileServer(t)
+	r := httptest.NewRequest("GET", "/files/2024-03-01/invoice.pdf", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code to be 200, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != PDFContentType || w.Body.String() != "%PDF-1.4" {
+		t.Errorf("Expected the PDF, got %s %q", w.Header().Get("Content-Type"), w.Body.String())
+	}
+
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", "/files/2024-03-01/invoice.pdf", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected status code to be 401 without token, got %d", w.Code)
+	}
+}
+
+func TestShouldDownloadStoredFileWithSignedURL(t *testing.T) {
+	s, _ := newFileServer(t)
+	signed := s.fileURL("2024-03-01/invoice.pdf", time.Now())
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest("GET", signed, nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status code to be 200 with a signed URL, got %d", w.Code)
+	}
+
+	expired := s.fileURL("2024-03-01/invoice.pdf", time.Now().Add(-2*time.Hour))
+	u, _ := url.Parse(signed)
+	tampered := "/files/2024-03-01/other.pdf?" + u.RawQuery
+	for _, target := range []string{expired, tampered} {
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status code to be 401 for %s, got %d", target, w.Code)
+		}
+	}
+}
+
+func TestShouldNotServeFilesOutsideTheOutputDirectory(t *testing.T) {
+	s, dir := newFileServer(t)
+	writeTestFile(t, filepath.Join(dir, "notes.txt"), "secret")
+	for _, id := range []string{"../outside.pdf", "notes.txt", "missing.pdf"} {
+		r := httptest.NewRequest("GET", "/files/x", nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		s.serveFile(w, r, id)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status code to be 404 for %s, got %d", id, w.Code)
+		}
+	}
+}
+
+func TestShouldListAndDeleteStoredFiles(t *testing.T) {
+	s, dir := newFileServer(t)
+	r := httptest.NewRequest("GET", "/files", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	var list struct {
+		Files []struct {
+			ID    string `json:"id"`
+			Bytes int64  `json:"bytes"`
+			URL   string `json:"url"`
+		} `json:"files"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Files) != 1 || list.Files[0].ID != "2024-03-01/invoice.pdf" || list.Files[0].Bytes != 8 || list.Files[0].URL == "" {
+		t.Errorf("Expected the invoice to be listed, got %+v", list.Files)
+	}
+
+	r = httptest.NewRequest("DELETE", "/files/2024-03-01/invoice.pdf", nil)
+	r.Header.Set("Authorization", "Bearer token")
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code to be 204, got %d", w.Code)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "2024-03-01", "invoice.pdf")); !os.IsNotExist(err) {
+		t.Error("Expected the file to be deleted")
+	}
+
+	w = httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, r)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code to be 404 once deleted, got %d", w.Code)
+	}
+}
+
+func TestShouldSignURLsOnlyWithSigningKey(t *testing.T) {
+	s := newServer(DefaultConfig())
+	if u := s.fileURL("a.pdf", time.Now()); u != "/files/a.pdf" {
+		t.Errorf("Expected an unsigned URL, got %s", u)
+	}
+	s, _ = newFileServer(t)
+	u, _ := url.Parse(s.fileURL("a.pdf", time.Unix(1000, 0)))
+	if u.Query().Get("expires") != strconv.Itoa(1000+3600) || u.Query().Get("signature") == "" {
+		t.Errorf("Expected a URL signed for an hour, got %s", u)
+	}
+}
+
+func TestShouldNotLeaveFilesOfFailedConversions(t *testing.T) {
+	s, dir := newFileServer(t)
+	s.ChromePath = filepath.Join(t.TempDir(), "no-chrome")
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?output=file&filename=x", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected status code to be 500 without Chrome, got %d", w.Code)
+	}
+	files, err := s.files.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].ID != "2024-03-01/invoice.pdf" {
+		t.Errorf("Expected no file to be left in %s, got %+v", dir, files)
+	}
+
+	p := PDF{exporters: map[string]Exporter{"file": s.files}}
+	if err := p.LoadSettings(map[string]string{"output": "file", "filename": "y"}, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.OpenExport(); err != nil {
+		t.Fatal(err)
+	}
+	p.Exporter.Write([]byte("%PDF-1.4 trunc"))
+	p.AbortExport()
+	if files, _ := s.files.List(); len(files) != 1 {
+		t.Errorf("Expected the aborted export to be removed, got %+v", files)
+	}
+}
@@ -0,0 +1,292 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/image/font/sfnt"
+)
+
+// ErrInvalidFont reports a font file that is not a TrueType, OpenType, WOFF or WOFF2 font.
+var ErrInvalidFont = errors.New("invalid font")
+
+// fontFormats are the formats of the @font-face rules and the media types of the font files, by extension.
+var fontFormats = map[string]struct{ format, mediaType string }{
+	".ttf":   {"truetype", "font/ttf"},
+	".otf":   {"opentype", "font/otf"},
+	".woff":  {"woff", "font/woff"},
+	".woff2": {"woff2", "font/woff2"},
+}
+
+// fontSignatures are the first bytes of the font files.
+var fontSignatures = [][]byte{[]byte("\x00\x01\x00\x00"), []byte("OTTO"), []byte("true"), []byte("wOFF"), []byte("wOF2")}
+
+// fontWeights are the weights named in the subfamilies of the fonts, e.g. Bold Italic.
+// The longer names come first, so that semibold is not taken for bold.
+var fontWeights = []struct {
+	name   string
+	weight int
+}{
+	{"extralight", 200}, {"ultralight", 200}, {"extrabold", 800}, {"ultrabold", 800},
+	{"semibold", 600}, {"demibold", 600}, {"hairline", 100}, {"thin", 100}, {"light", 300},
+	{"medium", 500}, {"bold", 700}, {"black", 900}, {"heavy", 900},
+}
+
+// Font describes a font file of a FontDir.
+type Font struct {
+	// File is the path of the file, relative to the directory, e.g. acme/AcmeSans-Bold.ttf.
+	File string `json:"file"`
+	// Family is the name to use in the CSS font-family properties, e.g. Acme Sans.
+	Family string `json:"family"`
+	Weight int    `json:"weight"`
+	// Style is normal or italic.
+	Style string `json:"style"`
+	// Format is the format of the @font-face rule: truetype, opentype, woff or woff2.
+	Format string `json:"format"`
+	Bytes  int64  `json:"bytes"`
+}
+
+// FontDir is a directory of font files, made available to the documents with @font-face rules (see PDF.FontFaces).
+// The family, weight and style of TrueType and OpenType fonts are read from the fonts themselves.
+// Those of WOFF and WOFF2 fonts come from their file name, e.g. AcmeSans-BoldItalic.woff2 is the bold italic Acme Sans.
+type FontDir struct {
+	Dir string
+
+	mu sync.Mutex
+	// css caches the rules of FontFaceCSS, for the files described by signature.
+	css       string
+	signature string
+}
+
+// validFontName reports whether name is a relative path to a font file of a font directory.
+func validFontName(name string) bool {
+	_, ok := fontFormats[strings.ToLower(path.Ext(name))]
+	return fs.ValidPath(name) && ok && !strings.HasPrefix(path.Base(name), ".")
+}
+
+// List returns the fonts of the directory, sorted by family, weight and style.
+func (d *FontDir) List() ([]Font, error) {
+	fonts := []Font{}
+	err := filepath.WalkDir(d.Dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(d.Dir, name)
+		if err != nil || entry.IsDir() || !validFontName(filepath.ToSlash(rel)) {
+			return err
+		}
+		content, err := os.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		font, err := describeFont(filepath.ToSlash(rel), content)
+		if err != nil {
+			getLogger().Warn("ignoring font", "file", rel, "error", err)
+			return nil
+		}
+		fonts = append(fonts, font)
+		return nil
+	})
+	sort.Slice(fonts, func(i, j int) bool {
+		if fonts[i].Family != fonts[j].Family {
+			return fonts[i].Family < fonts[j].Family
+		}
+		if fonts[i].Weight != fonts[j].Weight {
+			return fonts[i].Weight < fonts[j].Weight
+		}
+		if fonts[i].Style != fonts[j].Style {
+			return fonts[i].Style < fonts[j].Style
+		}
+		return fonts[i].File < fonts[j].File
+	})
+	return fonts, err
+}
+
+// Save writes a font file to the directory, replacing the file of the same name.
+// The content must be a font of the format of the extension of the name, or the error wraps ErrInvalidFont.
+func (d *FontDir) Save(name string, content []byte) (Font, error) {
+	if !validFontName(name) {
+		return Font{}, fmt.Errorf("%w: %q is not the name of a .ttf, .otf, .woff or .woff2 file", ErrInvalidFont, name)
+	}
+	font, err := describeFont(name, content)
+	if err != nil {
+		return Font{}, err
+	}
+	if err := os.MkdirAll(d.Dir, 0o755); err != nil {
+		return Font{}, fmt.Errorf("could not create font directory: %v", err)
+	}
+	root, err := os.OpenRoot(d.Dir)
+	if err != nil {
+		return Font{}, fmt.Errorf("could not open font directory: %v", err)
+	}
+	defer root.Close()
+	if dir := path.Dir(name); dir != "." {
+		if err := root.MkdirAll(dir, 0o755); err != nil {
+			return Font{}, fmt.Errorf("could not create font directory: %v", err)
+		}
+	}
+	if err := root.WriteFile(name, content, 0o644); err != nil {
+		return Font{}, fmt.Errorf("could not save font: %v", err)
+	}
+	return font, nil
+}
+
+// Remove removes a font file of the directory.
+func (d *FontDir) Remove(name string) error {
+	if !validFontName(name) {
+		return fmt.Errorf("%w: %q is not the name of a .ttf, .otf, .woff or .woff2 file", fs.ErrNotExist, name)
+	}
+	root, err := os.OpenRoot(d.Dir)
+	if err != nil {
+		return err
+	}
+	defer root.Close()
+	return root.Remove(name)
+}
+
+// FontFaceCSS returns the @font-face rules of the fonts of the directory, with the fonts embedded as data URLs.
+// The rules are kept until the files of the directory change: the fonts are read again only then.
+func (d *FontDir) FontFaceCSS() (string, error) {
+	signature, err := d.filesSignature()
+	if err != nil {
+		return "", err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if signature == d.signature {
+		return d.css, nil
+	}
+
+	fonts, err := d.List()
+	if err != nil {
+		return "", err
+	}
+	var css strings.Builder
+	for _, font := range fonts {
+		content, err := os.ReadFile(filepath.Join(d.Dir, filepath.FromSlash(font.File)))
+		if err != nil {
+			return "", err
+		}
+		family, _ := json.Marshal(font.Family)
+		fmt.Fprintf(&css, "@font-face { font-family: %s; font-weight: %d; font-style: %s; src: url(\"data:%s;base64,%s\") format(\"%s\"); }\n",
+			family, font.Weight, font.Style, fontFormats[strings.ToLower(path.Ext(font.File))].mediaType, base64.StdEncoding.EncodeToString(content), font.Format)
+	}
+	d.css, d.signature = css.String(), signature
+	return d.css, nil
+}
+
+// filesSignature describes the font files of the directory by their name, size and modification time,
+// which changes when a font is added, removed or replaced, without reading the files.
+func (d *FontDir) filesSignature() (string, error) {
+	var signature strings.Builder
+	err := filepath.WalkDir(d.Dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		rel, err := filepath.Rel(d.Dir, name)
+		if err != nil || entry.IsDir() || !validFontName(filepath.ToSlash(rel)) {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&signature, "%s\x00%d\x00%d\n", filepath.ToSlash(rel), info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	return signature.String(), err
+}
+
+// describeFont returns the description of a font file. The error wraps ErrInvalidFont when it is not a font.
+func describeFont(name string, content []byte) (Font, error) {
+	ext := strings.ToLower(path.Ext(name))
+	font := Font{File: name, Format: fontFormats[ext].format, Bytes: int64(len(content)), Weight: 400, Style: "normal"}
+	known := false
+	for _, signature := range fontSignatures {
+		known = known || bytes.HasPrefix(content, signature)
+	}
+	if !known {
+		return font, fmt.Errorf("%w: %s is not a font file", ErrInvalidFont, name)
+	}
+
+	// the family and subfamily of the file name, e.g. AcmeSans-BoldItalic
+	family, subfamily, _ := strings.Cut(strings.TrimSuffix(path.Base(name), path.Ext(name)), "-")
+	if ext == ".ttf" || ext == ".otf" {
+		f, err := sfnt.Parse(content)
+		if err != nil {
+			return font, fmt.Errorf("%w: %s: %v", ErrInvalidFont, name, err)
+		}
+		if value := fontName(f, sfnt.NameIDTypographicFamily, sfnt.NameIDFamily); value != "" {
+			family = value
+		}
+		if value := fontName(f, sfnt.NameIDTypographicSubfamily, sfnt.NameIDSubfamily); value != "" {
+			subfamily = value
+		}
+	}
+	font.Family = family
+	words := strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(subfamily))
+	for _, w := range fontWeights {
+		if strings.Contains(words, w.name) {
+			font.Weight = w.weight
+			break
+		}
+	}
+	if strings.Contains(words, "italic") || strings.Contains(words, "oblique") {
+		font.Style = "italic"
+	}
+	return font, nil
+}
+
+// fontName returns the first of the names of the font that is set.
+func fontName(f *sfnt.Font, ids ...sfnt.NameID) string {
+	for _, id := range ids {
+		if name, err := f.Name(nil, id); err == nil && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// addFontFacesScript adds the @font-face rules to the document, and waits for their fonts to be loaded.
+// Reading offsetHeight applies the new style sheet, so that its fonts are listed in document.fonts.
+const addFontFacesScript = `(function(css) {
+	const style = document.createElement("style");
+	style.textContent = css;
+	(document.head || document.documentElement).appendChild(style);
+	void document.documentElement.offsetHeight;
+	return Promise.all(Array.from(document.fonts, font => font.load().catch(() => null)))
+		.then(() => document.fonts.ready)
+		.then(() => true);
+})`
+
+// addFontFaces adds the FontFaces of the PDF to the document loaded in the tab of ctx.
+func (p *PDF) addFontFaces(ctx context.Context) error {
+	css, _ := json.Marshal(p.FontFaces)
+	var ok bool
+	err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf("%s(%s)", addFontFacesScript, css), &ok, func(params *runtime.EvaluateParams) *runtime.EvaluateParams {
+		return params.WithAwaitPromise(true)
+	}))
+	if err != nil {
+		return fmt.Errorf("could not load fonts: %v", err)
+	}
+	return nil
+}
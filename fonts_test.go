@@ -0,0 +1,178 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/image/font/gofont/gobolditalic"
+	"golang.org/x/image/font/gofont/goregular"
+)
+
+func TestShouldDescribeTheFontsOfADirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "Go-Regular.ttf"), string(goregular.TTF))
+	writeTestFile(t, filepath.Join(dir, "go", "Go-BoldItalic.ttf"), string(gobolditalic.TTF))
+	writeTestFile(t, filepath.Join(dir, "AcmeSans-SemiBoldItalic.woff2"), "wOF2 not really a font")
+	writeTestFile(t, filepath.Join(dir, "Broken.ttf"), "not a font")
+	writeTestFile(t, filepath.Join(dir, "README.txt"), "fonts")
+
+	fonts, err := (&FontDir{Dir: dir}).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Font{
+		{File: "AcmeSans-SemiBoldItalic.woff2", Family: "AcmeSans", Weight: 600, Style: "italic", Format: "woff2", Bytes: 22},
+		{File: "Go-Regular.ttf", Family: "Go", Weight: 400, Style: "normal", Format: "truetype", Bytes: int64(len(goregular.TTF))},
+		{File: "go/Go-BoldItalic.ttf", Family: "Go", Weight: 700, Style: "italic", Format: "truetype", Bytes: int64(len(gobolditalic.TTF))},
+	}
+	if len(fonts) != len(want) {
+		t.Fatalf("Expected %d fonts, got %+v", len(want), fonts)
+	}
+	for i := range want {
+		if fonts[i] != want[i] {
+			t.Errorf("Expected %+v, got %+v", want[i], fonts[i])
+		}
+	}
+
+	fonts, err = (&FontDir{Dir: filepath.Join(dir, "missing")}).List()
+	if err != nil || len(fonts) != 0 {
+		t.Errorf("Expected no fonts in a missing directory, got %v, %v", fonts, err)
+	}
+}
+
+func TestShouldWriteFontFaceRules(t *testing.T) {
+	d := &FontDir{Dir: t.TempDir()}
+	if _, err := d.Save("Go-BoldItalic.ttf", gobolditalic.TTF); err != nil {
+		t.Fatal(err)
+	}
+	css, err := d.FontFaceCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(css, `@font-face { font-family: "Go"; font-weight: 700; font-style: italic; src: url("data:font/ttf;base64,`) || !strings.Contains(css, `format("truetype")`) {
+		t.Errorf("Expected the rule of the font, got %.200s", css)
+	}
+
+	if _, err := d.Save("Go-Regular.ttf", goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	updated, err := d.FontFaceCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(updated, "@font-face") != 2 {
+		t.Errorf("Expected the rules to follow the directory, got %d rules", strings.Count(updated, "@font-face"))
+	}
+
+	for _, name := range []string{"../Go.ttf", "Go.exe", ".hidden.ttf"} {
+		if _, err := d.Save(name, goregular.TTF); err == nil {
+			t.Errorf("Expected %s to be rejected", name)
+		}
+	}
+}
+
+func TestShouldReadTheFontsOnlyWhenTheDirectoryChanges(t *testing.T) {
+	d := &FontDir{Dir: t.TempDir()}
+	if _, err := d.Save("Go-Regular.ttf", goregular.TTF); err != nil {
+		t.Fatal(err)
+	}
+	css, err := d.FontFaceCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the file is replaced by one of the same size and time, which only a read would notice
+	name := filepath.Join(d.Dir, "Go-Regular.ttf")
+	info, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(name, make([]byte, info.Size()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+	if cached, err := d.FontFaceCSS(); err != nil || cached != css {
+		t.Errorf("Expected the rules to be reused while the files do not change, got %v", err)
+	}
+
+	if err := os.Chtimes(name, info.ModTime().Add(time.Second), info.ModTime().Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if updated, err := d.FontFaceCSS(); err != nil || updated != "" {
+		t.Errorf("Expected the fonts to be read again once a file changes, got %.100q, %v", updated, err)
+	}
+}
+
+func TestShouldUploadFonts(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Fonts = FontsConfig{Dir: t.TempDir(), Upload: true}
+	h := newServer(cfg).Handler()
+	serve := func(method, target string, body []byte) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest(method, target, bytes.NewReader(body)))
+		return w
+	}
+
+	if w := serve("PUT", "/fonts/brand/Go-Regular.ttf", goregular.TTF); w.Code != http.StatusCreated || !strings.Contains(w.Body.String(), `"family":"Go"`) {
+		t.Errorf("Expected the font to be saved, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("PUT", "/fonts/Fake.ttf", []byte("<html></html>")); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400 for a file that is not a font, got %d", w.Code)
+	}
+	if w := serve("GET", "/fonts", nil); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"file":"brand/Go-Regular.ttf"`) {
+		t.Errorf("Expected the font to be listed, got %d %s", w.Code, w.Body.String())
+	}
+	if w := serve("DELETE", "/fonts/brand/Go-Regular.ttf", nil); w.Code != http.StatusNoContent {
+		t.Errorf("Expected status code to be 204, got %d", w.Code)
+	}
+	if w := serve("DELETE", "/fonts/brand/Go-Regular.ttf", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status code to be 404, got %d", w.Code)
+	}
+
+	cfg.Fonts.Upload = false
+	w := httptest.NewRecorder()
+	newServer(cfg).Handler().ServeHTTP(w, httptest.NewRequest("PUT", "/fonts/Go-Regular.ttf", bytes.NewReader(goregular.TTF)))
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected status code to be 403 when uploads are disabled, got %d", w.Code)
+	}
+}
+
+func TestShouldEmbedTheFontsInThePDF(t *testing.T) {
+	if _, err := (&Server{}).resolveChromePath(); err != nil {
+		t.Skip("chrome is not available:", err)
+	}
+	d := &FontDir{Dir: t.TempDir()}
+	if _, err := d.Save("Go-BoldItalic.ttf", gobolditalic.TTF); err != nil {
+		t.Fatal(err)
+	}
+	css, err := d.FontFaceCSS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := []byte(`<html><body><p style="font-family: Go; font-weight: bold; font-style: italic">Hello World</p></body></html>`)
+
+	p := PDF{FontFaces: css}
+	if err := p.Render(context.Background(), html); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(p.Content, []byte("Go-BoldItalic")) {
+		t.Error("Expected the font to be embedded in the PDF")
+	}
+
+	var without PDF
+	if err := without.Render(context.Background(), html); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(without.Content, []byte("Go-BoldItalic")) {
+		t.Error("Expected the font not to be embedded without its @font-face rule")
+	}
+}
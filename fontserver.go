@@ -0,0 +1,71 @@
+﻿package lazypress
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+)
+
+// handleListFonts lists the fonts of the font directory, which are available to every document.
+func (s *Server) handleListFonts(w http.ResponseWriter, r *http.Request) {
+	fonts := []Font{}
+	if s.fonts != nil {
+		var err error
+		if fonts, err = s.fonts.List(); err != nil {
+			writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"fonts": fonts})
+}
+
+// handleUploadFont saves the font file in the body of the request to the font directory, replacing the one of the same name.
+func (s *Server) handleUploadFont(w http.ResponseWriter, r *http.Request) {
+	if s.fonts == nil || !s.config.Fonts.Upload {
+		writeProblem(w, r, http.StatusForbidden, "Font uploads are disabled.", nil)
+		return
+	}
+	if s.config.Limits.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.Limits.MaxBodyBytes)
+	}
+	content, err := io.ReadAll(r.Body)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error(), nil)
+			return
+		}
+		writeProblem(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	font, err := s.fonts.Save(r.PathValue("file"), content)
+	if errors.Is(err, ErrInvalidFont) {
+		writeProblem(w, r, http.StatusBadRequest, err.Error(), nil)
+		return
+	}
+	if err != nil {
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	loggerWithRequestID(requestIDFromRequest(r)).Info("font saved", "file", font.File, "family", font.Family)
+	writeJSON(w, http.StatusCreated, font)
+}
+
+// handleDeleteFont removes a font file of the font directory.
+func (s *Server) handleDeleteFont(w http.ResponseWriter, r *http.Request) {
+	if s.fonts == nil || !s.config.Fonts.Upload {
+		writeProblem(w, r, http.StatusForbidden, "Font uploads are disabled.", nil)
+		return
+	}
+	if err := s.fonts.Remove(r.PathValue("file")); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			writeProblem(w, r, http.StatusNotFound, "The font does not exist.", nil)
+			return
+		}
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	loggerWithRequestID(requestIDFromRequest(r)).Info("font deleted", "file", r.PathValue("file"))
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,237 @@
+﻿package lazypress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// readinessHTML is the trivial page printed to check that Chrome is able to render.
+var readinessHTML = []byte("<html><body>lazypress</body></html>")
+
+// chromeCandidates are the executables looked up in the PATH when no chrome path is configured.
+// They mirror the ones [github.com/chromedp/chromedp] tries by default.
+var chromeCandidates = []string{
+	"headless_shell",
+	"headless-shell",
+	"chromium",
+	"chromium-browser",
+	"google-chrome",
+	"google-chrome-stable",
+	"google-chrome-beta",
+	"google-chrome-unstable",
+	"/Applications/Google Chrome.app/Contents/MacOS/Google Chrome",
+	"chrome.exe",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// resolveChromePath returns the path of the chrome executable the server will use.
+func (s *Server) resolveChromePath() (string, error) {
+	if s.ChromePath != "" {
+		info, err := os.Stat(s.ChromePath)
+		if err != nil {
+			return "", err
+		}
+		if info.IsDir() {
+			return "", fmt.Errorf("%s is a directory", s.ChromePath)
+		}
+		return s.ChromePath, nil
+	}
+	for _, name := range chromeCandidates {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("could not find a chrome executable")
+}
+
+// handleHealthz reports that the process is alive.
+func (s *Server) handleHealthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleReadyz reports whether the server is able to render PDFs.
+// It checks that the chrome executable can be found and that a trivial page can be printed within ReadinessTimeout.
+// The test page goes through the render queue, and its result is reused for ReadinessInterval.
+func (s *Server) handleReadyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]string{}
+	status := http.StatusOK
+	fail := func(check string, err error) {
+		checks[check] = err.Error()
+		status = http.StatusServiceUnavailable
+	}
+
+	if s.isDraining() {
+		fail("server", fmt.Errorf("server is shutting down"))
+	} else {
+		checks["server"] = "ok"
+	}
+
+	if _, err := s.resolveChromePath(); err != nil {
+		fail("chrome", err)
+	} else {
+		checks["chrome"] = "ok"
+		if err := s.checkRender(r.Context()); err != nil {
+			fail("render", err)
+		} else {
+			checks["render"] = "ok"
+		}
+	}
+
+	result := map[string]any{"status": "ready", "checks": checks}
+	if status != http.StatusOK {
+		result["status"] = "not ready"
+	}
+	writeJSON(w, status, result)
+}
+
+// checkRender prints the test page, unless it was printed less than ReadinessInterval ago, in which case its result is reused.
+// The concurrent checks wait for the one in progress.
+func (s *Server) checkRender(ctx context.Context) error {
+	s.readyMu.Lock()
+	defer s.readyMu.Unlock()
+	if !s.readyAt.IsZero() && time.Since(s.readyAt) < s.ReadinessInterval {
+		return s.readyErr
+	}
+	err := s.printTestPage(ctx)
+	// the result of a probe that went away says nothing about Chrome
+	if ctx.Err() == nil {
+		s.readyAt, s.readyErr = time.Now(), err
+	}
+	return err
+}
+
+// printTestPage prints readinessHTML within ReadinessTimeout, waiting for its turn in the render queue like a batch conversion.
+func (s *Server) printTestPage(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, s.ReadinessTimeout)
+	defer cancel()
+	release, err := s.waitForRender(ctx, map[string]string{"priority": priorityBatch})
+	if err != nil {
+		return fmt.Errorf("could not print the test page: %w", err)
+	}
+	defer release()
+
+	allocatorCtx, allocatorCancel := s.newAllocator()
+	defer allocatorCancel()
+	// stop the render when the request is done
+	go func() {
+		<-ctx.Done()
+		allocatorCancel()
+	}()
+
+	var p PDF
+	if err := p.generateWithChrome(allocatorCtx, readinessHTML); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("test page was not printed within %s", s.ReadinessTimeout)
+		}
+		return err
+	}
+	if len(p.Content) == 0 {
+		return fmt.Errorf("test page is empty")
+	}
+	return nil
+}
+
+type chromeVersion struct {
+	Product         string `json:"product"`
+	Revision        string `json:"revision"`
+	ProtocolVersion string `json:"protocolVersion"`
+	UserAgent       string `json:"userAgent"`
+	JSVersion       string `json:"jsVersion"`
+}
+
+type chromeTarget struct {
+	Started string  `json:"started"`
+	Elapsed float64 `json:"elapsedSeconds"`
+	Size    int     `json:"htmlBytes"`
+}
+
+type chromeDiagnostics struct {
+	ExecPath string         `json:"execPath"`
+	Version  *chromeVersion `json:"version,omitempty"`
+	Error    string         `json:"error,omitempty"`
+	Pool     struct {
+		ActiveRenders int  `json:"activeRenders"`
+		Waiting       int  `json:"waiting"`
+		Draining      bool `json:"draining"`
+	} `json:"pool"`
+	Targets []chromeTarget `json:"targets"`
+}
+
+// handleDebugChrome reports the Chrome version, the state of the server and the renders in progress.
+func (s *Server) handleDebugChrome(w http.ResponseWriter, r *http.Request) {
+	var d chromeDiagnostics
+	status := http.StatusOK
+
+	execPath, err := s.resolveChromePath()
+	if err != nil {
+		d.Error = err.Error()
+		status = http.StatusServiceUnavailable
+	} else {
+		d.ExecPath = execPath
+		version, err := s.chromeVersion(r.Context())
+		if err != nil {
+			d.Error = err.Error()
+			status = http.StatusServiceUnavailable
+		}
+		d.Version = version
+	}
+
+	renders := s.activeRenders()
+	d.Pool.ActiveRenders = len(renders)
+	_, d.Pool.Waiting = s.queue.stats()
+	d.Pool.Draining = s.isDraining()
+	d.Targets = make([]chromeTarget, 0, len(renders))
+	for _, render := range renders {
+		d.Targets = append(d.Targets, chromeTarget{
+			Started: render.Started.Format(time.RFC3339),
+			Elapsed: time.Since(render.Started).Seconds(),
+			Size:    render.Size,
+		})
+	}
+
+	writeJSON(w, status, d)
+}
+
+// chromeVersion starts Chrome to get its version, waiting for its turn in the render queue like a batch conversion.
+func (s *Server) chromeVersion(ctx context.Context) (*chromeVersion, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.ReadinessTimeout)
+	defer cancel()
+	release, err := s.waitForRender(ctx, map[string]string{"priority": priorityBatch})
+	if err != nil {
+		return nil, fmt.Errorf("could not get chrome version: %v", err)
+	}
+	defer release()
+
+	allocatorCtx, allocatorCancel := s.newAllocator()
+	defer allocatorCancel()
+	go func() {
+		<-ctx.Done()
+		allocatorCancel()
+	}()
+
+	chromeCtx, chromeCancel := chromedp.NewContext(allocatorCtx)
+	defer chromeCancel()
+
+	var v chromeVersion
+	if err := chromedp.Run(chromeCtx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		v.ProtocolVersion, v.Product, v.Revision, v.UserAgent, v.JSVersion, err = browser.GetVersion().Do(ctx)
+		return err
+	})); err != nil {
+		return nil, fmt.Errorf("could not get chrome version: %v", err)
+	}
+	return &v, nil
+}
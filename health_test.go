@@ -0,0 +1,92 @@
+﻿package lazypress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+)
+
+func TestShouldReportHealthy(t *testing.T) {
+	s := NewServer(0, "")
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	result := w.Result()
+	defer result.Body.Close()
+	if result.StatusCode != http.StatusOK {
+		t.Errorf("Expected status code to be 200, got %d", result.StatusCode)
+	}
+}
+
+func TestShouldNotBeReadyWhenChromeIsMissing(t *testing.T) {
+	s := NewServer(0, path.Join(t.TempDir(), "chrome"))
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	result := w.Result()
+	defer result.Body.Close()
+	if result.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code to be 503, got %d", result.StatusCode)
+	}
+	var body struct {
+		Checks map[string]string `json:"checks"`
+	}
+	if err := json.NewDecoder(result.Body).Decode(&body); err != nil {
+		t.Error(err)
+	}
+	if body.Checks["chrome"] == "ok" {
+		t.Error("Expected chrome check to fail")
+	}
+	if _, ok := body.Checks["render"]; ok {
+		t.Error("Expected render check to be skipped when chrome is missing")
+	}
+}
+
+func TestShouldReportActiveRendersInChromeDiagnostics(t *testing.T) {
+	s := NewServer(0, path.Join(t.TempDir(), "chrome"))
+	done, err := s.trackRender(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer done()
+	req := httptest.NewRequest("GET", "/debug/chrome", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	result := w.Result()
+	defer result.Body.Close()
+	var d chromeDiagnostics
+	if err := json.NewDecoder(result.Body).Decode(&d); err != nil {
+		t.Error(err)
+	}
+	if d.Pool.ActiveRenders != 1 {
+		t.Errorf("Expected 1 active render, got %d", d.Pool.ActiveRenders)
+	}
+	if len(d.Targets) != 1 || d.Targets[0].Size != 42 {
+		t.Errorf("Expected the active render to be listed, got %+v", d.Targets)
+	}
+	if d.Error == "" {
+		t.Error("Expected an error when chrome is missing")
+	}
+}
+
+func TestShouldCheckRendersThroughTheQueueAndReuseTheResult(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Queue.MaxConcurrent, cfg.Queue.MaxWaiting = 1, 0
+	s := newServer(cfg)
+	release, err := s.queue.acquire(context.Background(), priorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.checkRender(context.Background()); !errors.Is(err, errQueueFull) {
+		t.Errorf("Expected the test page to wait for the queue, got %v", err)
+	}
+	release()
+	checked := s.readyAt
+	if err := s.checkRender(context.Background()); err == nil || s.readyAt != checked {
+		t.Errorf("Expected the last result to be reused, got %v", err)
+	}
+}
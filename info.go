@@ -0,0 +1,133 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu"
+)
+
+// Headers of the responses to /convert describing the PDF, along with Server-Timing (see DocumentInfo).
+const (
+	PagesHeader     = "X-PDF-Pages"
+	BytesHeader     = "X-PDF-Bytes"
+	PaperSizeHeader = "X-PDF-Paper-Size"
+	SHA256Header    = "X-PDF-SHA256"
+)
+
+// RenderTimings are the durations of the phases of a conversion.
+type RenderTimings struct {
+	// Queue is the time spent waiting in the render queue of the server.
+	Queue time.Duration
+	// Load is the time spent loading the page.
+	Load time.Duration
+	// Print is the time spent printing the PDF, including the table of contents and the page templates.
+	Print time.Duration
+	// Render is the time spent rendering the PDF, from the start of Chrome to the end of the print.
+	Render time.Duration
+}
+
+// MarshalJSON writes the timings in seconds.
+func (t RenderTimings) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Queue  float64 `json:"queueSeconds"`
+		Load   float64 `json:"loadSeconds"`
+		Print  float64 `json:"printSeconds"`
+		Render float64 `json:"renderSeconds"`
+	}{t.Queue.Seconds(), t.Load.Seconds(), t.Print.Seconds(), t.Render.Seconds()})
+}
+
+// serverTiming returns the timings as a Server-Timing header, in milliseconds.
+// The phases that did not happen, e.g. for a cached PDF, are left out.
+func (t RenderTimings) serverTiming() string {
+	var metrics []string
+	for _, phase := range []struct {
+		name     string
+		duration time.Duration
+	}{{"queue", t.Queue}, {"load", t.Load}, {"print", t.Print}, {"render", t.Render}} {
+		if phase.duration > 0 {
+			metrics = append(metrics, fmt.Sprintf("%s;dur=%s", phase.name, strconv.FormatFloat(float64(phase.duration.Microseconds())/1000, 'f', -1, 64)))
+		}
+	}
+	return strings.Join(metrics, ", ")
+}
+
+// DocumentInfo describes a generated PDF.
+type DocumentInfo struct {
+	Pages int   `json:"pages"`
+	Bytes int64 `json:"bytes"`
+	// PaperWidth and PaperHeight are the size of the first page, in inches.
+	PaperWidth  float64 `json:"paperWidth"`
+	PaperHeight float64 `json:"paperHeight"`
+	// SHA256 is the hex encoded SHA-256 of the PDF.
+	SHA256  string        `json:"sha256"`
+	Timings RenderTimings `json:"timings"`
+}
+
+// Info describes the generated PDF. It is not available for streamed PDFs, which are not kept in Content.
+func (p *PDF) Info() (DocumentInfo, error) {
+	if p.Content == nil {
+		return DocumentInfo{}, fmt.Errorf("no PDF content")
+	}
+	sum := sha256.Sum256(p.Content)
+	info := DocumentInfo{
+		Bytes:   int64(len(p.Content)),
+		SHA256:  hex.EncodeToString(sum[:]),
+		Timings: p.Timings,
+	}
+	dims, err := api.PageDims(bytes.NewReader(p.Content), pdfConfig())
+	if err != nil {
+		return info, fmt.Errorf("could not read PDF pages: %v", err)
+	}
+	info.Pages = len(dims)
+	if len(dims) > 0 {
+		// the sizes are in points, rounded to the hundredth of an inch
+		info.PaperWidth = math.Round(dims[0].Width/72*100) / 100
+		info.PaperHeight = math.Round(dims[0].Height/72*100) / 100
+	}
+	return info, nil
+}
+
+// setHeaders describes the PDF in the headers of the response.
+func (info DocumentInfo) setHeaders(h http.Header) {
+	h.Set(PagesHeader, strconv.Itoa(info.Pages))
+	h.Set(BytesHeader, strconv.FormatInt(info.Bytes, 10))
+	h.Set(PaperSizeHeader, formatInches(info.PaperWidth)+"x"+formatInches(info.PaperHeight)+"in")
+	h.Set(SHA256Header, info.SHA256)
+	if timing := info.Timings.serverTiming(); timing != "" {
+		h.Set("Server-Timing", timing)
+	}
+}
+
+// setProperties sets entries of the document information dictionary of a PDF, e.g. Author.
+func setProperties(content []byte, properties map[string]string) ([]byte, error) {
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(content), pdfConfig())
+	if err != nil {
+		return nil, fmt.Errorf("could not set PDF properties: %v", err)
+	}
+	if err := pdfcpu.PropertiesAdd(ctx, properties); err != nil {
+		return nil, fmt.Errorf("could not set PDF properties: %v", err)
+	}
+	var out bytes.Buffer
+	if err := api.WriteContext(ctx, &out); err != nil {
+		return nil, fmt.Errorf("could not set PDF properties: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// convertResponse is the JSON response to /convert, when the PDF is not the response itself.
+type convertResponse struct {
+	*ExportResult
+	Info *DocumentInfo `json:"info,omitempty"`
+	// Content is the PDF, encoded in base64, when it is downloaded in JSON.
+	Content []byte `json:"content,omitempty"`
+}
@@ -0,0 +1,94 @@
+﻿package lazypress
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShouldDescribeThePDF(t *testing.T) {
+	content := newTestPDF("one", "two")
+	p := PDF{Content: content, Timings: RenderTimings{Load: 1500 * time.Millisecond, Print: 250 * time.Microsecond}}
+	info, err := p.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(content)
+	if info.Pages != 2 || info.Bytes != int64(len(content)) || info.PaperWidth != 8.5 || info.PaperHeight != 11 || info.SHA256 != hex.EncodeToString(sum[:]) {
+		t.Errorf("Expected the description of the PDF, got %+v", info)
+	}
+
+	h := http.Header{}
+	info.setHeaders(h)
+	for header, want := range map[string]string{
+		PagesHeader:     "2",
+		PaperSizeHeader: "8.5x11in",
+		SHA256Header:    info.SHA256,
+		"Server-Timing": "load;dur=1500, print;dur=0.25",
+	} {
+		if got := h.Get(header); got != want {
+			t.Errorf("Expected %s to be %q, got %q", header, want, got)
+		}
+	}
+
+	timings, _ := json.Marshal(info.Timings)
+	if string(timings) != `{"queueSeconds":0,"loadSeconds":1.5,"printSeconds":0.00025,"renderSeconds":0}` {
+		t.Errorf("Expected the timings in seconds, got %s", timings)
+	}
+
+	if _, err := (&PDF{}).Info(); err == nil {
+		t.Error("Expected an error without content")
+	}
+}
+
+func TestShouldDescribeThePDFInTheResponse(t *testing.T) {
+	s, key := newCachingServer(t)
+	content := newTestPDF("one")
+	s.cache.Set(key, content)
+
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?landscape=true", "<html><body>Hello World</body></html>"))
+	if w.Body.String() != string(content) || w.Header().Get(PagesHeader) != "1" || w.Header().Get(BytesHeader) == "" {
+		t.Errorf("Expected the PDF and its description in the headers, got %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?landscape=true&info=true", "<html><body>Hello World</body></html>"))
+	var res struct {
+		Output  string       `json:"output"`
+		Info    DocumentInfo `json:"info"`
+		Content []byte       `json:"content"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Expected a JSON response, got %q: %v", w.Body.String(), err)
+	}
+	if res.Output != "download" || res.Info.Pages != 1 || string(res.Content) != string(content) {
+		t.Errorf("Expected the PDF and its description, got %+v", res)
+	}
+}
+
+func TestShouldNotWriteThePDFConfigToTheUserConfigDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("HOME", dir)
+	disablePDFConfigDir = sync.Once{}
+	p := PDF{Content: newTestPDF("one")}
+	if _, err := p.Info(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := setProperties(p.Content, map[string]string{"Author": "Jane"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := destinationPages(p.Content, []string{"lazypress-toc-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) > 0 {
+		t.Errorf("Expected nothing to be written to the config directory, got %v", entries)
+	}
+}
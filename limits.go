@@ -0,0 +1,86 @@
+﻿package lazypress
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned when a render goes over one of its RenderLimits.
+// They are wrapped, so use errors.Is to check for them.
+var (
+	ErrLoadTimeout   = errors.New("the page took too long to load")
+	ErrPrintTimeout  = errors.New("the page took too long to print")
+	ErrRenderTimeout = errors.New("the render took too long")
+	ErrHTMLTooLarge  = errors.New("the HTML is too large")
+	ErrTooManyPages  = errors.New("the PDF has too many pages")
+	ErrPDFTooLarge   = errors.New("the PDF is too large")
+)
+
+// RenderLimits bound the resources used by a render. The zero value of each limit means no limit.
+type RenderLimits struct {
+	// LoadTimeout is how long Chrome may take to load the page, until its load event fires.
+	LoadTimeout time.Duration
+	// PrintTimeout is how long Chrome may take to print the loaded page.
+	PrintTimeout time.Duration
+	// Timeout is how long the whole render may take, including starting Chrome.
+	Timeout time.Duration
+	// MaxHTMLBytes is the maximum size of the HTML to render.
+	MaxHTMLBytes int
+	// MaxPages is the maximum number of pages of the PDF. It is not checked when the PDF is streamed.
+	MaxPages int
+	// MaxPDFBytes is the maximum size of the PDF.
+	MaxPDFBytes int64
+}
+
+// limitNames are the names of the limits in the configuration, as reported in the problems of the server.
+var limitNames = []struct {
+	err  error
+	name string
+}{
+	{ErrLoadTimeout, "loadTimeout"},
+	{ErrPrintTimeout, "printTimeout"},
+	{ErrRenderTimeout, "renderTimeout"},
+	{ErrHTMLTooLarge, "maxHTMLBytes"},
+	{ErrTooManyPages, "maxPages"},
+	{ErrPDFTooLarge, "maxPDFBytes"},
+}
+
+// exceededLimit returns the name of the limit err is about, or "" if it is not about a limit.
+func exceededLimit(err error) string {
+	for _, limit := range limitNames {
+		if errors.Is(err, limit.err) {
+			return limit.name
+		}
+	}
+	return ""
+}
+
+// withTimeout returns a context done after the timeout, if any, whose cause is then err.
+func withTimeout(ctx context.Context, timeout time.Duration, err error) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, timeout, err)
+}
+
+// limitError returns why ctx is done when it is, so that a timeout is reported as such
+// instead of as the error of the Chrome command it interrupted.
+func limitError(ctx context.Context, msg string, err error) error {
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", msg, context.Cause(ctx))
+	}
+	return fmt.Errorf("%s: %v", msg, err)
+}
+
+// checkPDF checks the printed PDF against the page count and size limits.
+func (l RenderLimits) checkPDF(content []byte) error {
+	if l.MaxPDFBytes > 0 && int64(len(content)) > l.MaxPDFBytes {
+		return fmt.Errorf("%w: %d bytes, the maximum is %d", ErrPDFTooLarge, len(content), l.MaxPDFBytes)
+	}
+	if pages := countPages(content); l.MaxPages > 0 && pages > l.MaxPages {
+		return fmt.Errorf("%w: %d pages, the maximum is %d", ErrTooManyPages, pages, l.MaxPages)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+﻿package lazypress
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestShouldCheckPDFsAgainstTheLimits(t *testing.T) {
+	pdf := []byte("%PDF-1.4 /Type /Pages /Type /Page /Type /Page /Type /Page")
+	for _, tc := range []struct {
+		limits   RenderLimits
+		expected error
+	}{
+		{RenderLimits{}, nil},
+		{RenderLimits{MaxPages: 3, MaxPDFBytes: int64(len(pdf))}, nil},
+		{RenderLimits{MaxPages: 2}, ErrTooManyPages},
+		{RenderLimits{MaxPDFBytes: 10}, ErrPDFTooLarge},
+	} {
+		if err := tc.limits.checkPDF(pdf); !errors.Is(err, tc.expected) {
+			t.Errorf("Expected %+v to return %v, got %v", tc.limits, tc.expected, err)
+		}
+	}
+}
+
+func TestShouldRejectHTMLOverTheLimit(t *testing.T) {
+	p := PDF{Limits: RenderLimits{MaxHTMLBytes: 10}}
+	err := p.Render(context.Background(), []byte("<html><body>Hello World</body></html>"))
+	if !errors.Is(err, ErrHTMLTooLarge) {
+		t.Errorf("Expected ErrHTMLTooLarge, got %v", err)
+	}
+}
+
+func TestShouldReportTheTimeoutThatExpired(t *testing.T) {
+	renderCtx, cancelRender := withTimeout(context.Background(), time.Hour, ErrRenderTimeout)
+	defer cancelRender()
+	loadCtx, cancelLoad := withTimeout(renderCtx, time.Nanosecond, ErrLoadTimeout)
+	defer cancelLoad()
+	<-loadCtx.Done()
+	if err := limitError(loadCtx, "could not load page in browser", context.DeadlineExceeded); !errors.Is(err, ErrLoadTimeout) {
+		t.Errorf("Expected ErrLoadTimeout, got %v", err)
+	}
+
+	renderCtx, cancelRender = withTimeout(context.Background(), time.Nanosecond, ErrRenderTimeout)
+	defer cancelRender()
+	printCtx, cancelPrint := withTimeout(renderCtx, time.Hour, ErrPrintTimeout)
+	defer cancelPrint()
+	<-printCtx.Done()
+	if err := limitError(printCtx, "could not create PDF", context.DeadlineExceeded); !errors.Is(err, ErrRenderTimeout) {
+		t.Errorf("Expected ErrRenderTimeout, got %v", err)
+	}
+
+	ctx, cancel := withTimeout(context.Background(), 0, ErrPrintTimeout)
+	defer cancel()
+	if err := limitError(ctx, "could not create PDF", errors.New("boom")); exceededLimit(err) != "" {
+		t.Errorf("Expected an error unrelated to the limits, got %v", err)
+	}
+}
+
+func TestShouldTimeOutRenders(t *testing.T) {
+	p := PDF{Limits: RenderLimits{Timeout: time.Nanosecond}}
+	err := p.Render(context.Background(), []byte("<html><body>Hello World</body></html>"))
+	if !errors.Is(err, ErrRenderTimeout) {
+		t.Errorf("Expected ErrRenderTimeout, got %v", err)
+	}
+}
+
+func TestShouldNameTheExceededLimitInProblems(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Limits.MaxHTMLBytes = 10
+	cfg.Queue.MaxConcurrent = 0
+	w := httptest.NewRecorder()
+	newServer(cfg).handleConvert(w, newConvertRequest(t, "/convert", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code to be 413, got %d", w.Code)
+	}
+	var problem Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Limit != "maxHTMLBytes" || !strings.Contains(problem.Detail, "HTML is too large") {
+		t.Errorf("Expected the problem to name maxHTMLBytes, got %+v", problem)
+	}
+
+	for err, status := range map[error]int{
+		ErrLoadTimeout:  http.StatusGatewayTimeout,
+		ErrTooManyPages: http.StatusUnprocessableEntity,
+		ErrPDFTooLarge:  http.StatusUnprocessableEntity,
+	} {
+		if limitStatus(err) != status {
+			t.Errorf("Expected %v to return %d, got %d", err, status, limitStatus(err))
+		}
+	}
+}
@@ -0,0 +1,126 @@
+﻿package lazypress
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"strings"
+	"sync/atomic"
+
+	cdplog "github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/runtime"
+)
+
+// RequestIDHeader is the HTTP header carrying the ID of a request.
+// When a request does not have it, the server generates one.
+// The ID is sent back in the response with the same header.
+const RequestIDHeader = "X-Request-ID"
+
+var logger atomic.Pointer[slog.Logger]
+
+// SetLogger sets the logger used by lazypress.
+// By default, lazypress logs with [slog.Default].
+func SetLogger(l *slog.Logger) {
+	logger.Store(l)
+}
+
+func getLogger() *slog.Logger {
+	if l := logger.Load(); l != nil {
+		return l
+	}
+	return slog.Default()
+}
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the given request ID.
+// The request ID is added to every log line lazypress writes for that context.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID carried by ctx, if any.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// loggerWithRequestID returns the lazypress logger, tagged with the request ID if there is one.
+func loggerWithRequestID(id string) *slog.Logger {
+	l := getLogger()
+	if id != "" {
+		l = l.With("request_id", id)
+	}
+	return l
+}
+
+// logger returns the logger to use for the PDF.
+func (p *PDF) logger() *slog.Logger {
+	return loggerWithRequestID(p.RequestID)
+}
+
+// logBrowserEvent forwards the console messages, page errors and browser log entries to the logger.
+func logBrowserEvent(l *slog.Logger, ev interface{}) {
+	switch ev := ev.(type) {
+	case *runtime.EventConsoleAPICalled:
+		args := make([]string, 0, len(ev.Args))
+		for _, arg := range ev.Args {
+			args = append(args, remoteObjectString(arg))
+		}
+		level := slog.LevelInfo
+		switch ev.Type {
+		case runtime.APITypeError, runtime.APITypeAssert:
+			level = slog.LevelError
+		case runtime.APITypeWarning:
+			level = slog.LevelWarn
+		case runtime.APITypeDebug:
+			level = slog.LevelDebug
+		}
+		l.Log(context.Background(), level, "chrome console", "type", ev.Type.String(), "message", strings.Join(args, " "))
+	case *runtime.EventExceptionThrown:
+		if ev.ExceptionDetails == nil {
+			return
+		}
+		msg := ev.ExceptionDetails.Text
+		if ev.ExceptionDetails.Exception != nil {
+			msg = remoteObjectString(ev.ExceptionDetails.Exception)
+		}
+		l.Error("chrome page error", "error", msg, "url", ev.ExceptionDetails.URL, "line", ev.ExceptionDetails.LineNumber)
+	case *cdplog.EventEntryAdded:
+		if ev.Entry == nil {
+			return
+		}
+		level := slog.LevelInfo
+		switch ev.Entry.Level {
+		case cdplog.LevelError:
+			level = slog.LevelError
+		case cdplog.LevelWarning:
+			level = slog.LevelWarn
+		case cdplog.LevelVerbose:
+			level = slog.LevelDebug
+		}
+		l.Log(context.Background(), level, "chrome log", "source", ev.Entry.Source.String(), "message", ev.Entry.Text, "url", ev.Entry.URL)
+	}
+}
+
+func remoteObjectString(o *runtime.RemoteObject) string {
+	if o == nil {
+		return ""
+	}
+	if len(o.Value) > 0 {
+		return strings.Trim(string(o.Value), `"`)
+	}
+	if o.Description != "" {
+		return o.Description
+	}
+	return o.Type.String()
+}
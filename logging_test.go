@@ -0,0 +1,83 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/runtime"
+)
+
+func captureLogs(t *testing.T) *bytes.Buffer {
+	var buf bytes.Buffer
+	SetLogger(slog.New(slog.NewJSONHandler(&buf, nil)))
+	t.Cleanup(func() {
+		SetLogger(nil)
+	})
+	return &buf
+}
+
+func TestShouldPropagateRequestIDHeader(t *testing.T) {
+	logs := captureLogs(t)
+	html := `<html><body>Hello World</body></html>`
+	req, err := http.NewRequest("POST", "/convert", strings.NewReader(html))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", fmt.Sprint((len(html))))
+	req.Header.Set(RequestIDHeader, "my-request")
+	w := httptest.NewRecorder()
+	NewServer(0, "").handleConvert(w, req)
+	if id := w.Result().Header.Get(RequestIDHeader); id != "my-request" {
+		t.Errorf("Expected request ID to be my-request, got %s", id)
+	}
+	if !strings.Contains(logs.String(), `"request_id":"my-request"`) {
+		t.Errorf("Expected logs to contain the request ID, got %s", logs.String())
+	}
+}
+
+func TestShouldGenerateRequestIDWhenMissing(t *testing.T) {
+	req := httptest.NewRequest("POST", "/convert", nil)
+	if id := requestIDFromRequest(req); len(id) != 32 {
+		t.Errorf("Expected a generated request ID, got %q", id)
+	}
+}
+
+func TestShouldLogExportWithRequestID(t *testing.T) {
+	logs := captureLogs(t)
+	p := PDF{RequestID: "export-request", Exporter: &mockWriter{}}
+	if err := p.Export(); err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(logs.String(), `"request_id":"export-request"`) {
+		t.Errorf("Expected logs to contain the request ID, got %s", logs.String())
+	}
+}
+
+func TestShouldCarryRequestIDInContext(t *testing.T) {
+	ctx := WithRequestID(context.Background(), "ctx-request")
+	if id := RequestIDFromContext(ctx); id != "ctx-request" {
+		t.Errorf("Expected request ID to be ctx-request, got %s", id)
+	}
+	if id := RequestIDFromContext(context.Background()); id != "" {
+		t.Errorf("Expected no request ID, got %s", id)
+	}
+}
+
+func TestShouldLogChromeConsoleMessages(t *testing.T) {
+	var buf bytes.Buffer
+	l := slog.New(slog.NewJSONHandler(&buf, nil))
+	logBrowserEvent(l, &runtime.EventConsoleAPICalled{
+		Type: runtime.APITypeWarning,
+		Args: []*runtime.RemoteObject{{Type: runtime.TypeString, Value: []byte(`"careful"`)}},
+	})
+	if !strings.Contains(buf.String(), `"level":"WARN"`) || !strings.Contains(buf.String(), `"message":"careful"`) {
+		t.Errorf("Expected console warning to be logged, got %s", buf.String())
+	}
+}
@@ -0,0 +1,244 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/styles"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	goldmarkhtml "github.com/yuin/goldmark/renderer/html"
+	"gopkg.in/yaml.v3"
+)
+
+// MarkdownContentType is the content type of the Markdown documents.
+const MarkdownContentType = "text/markdown"
+
+// DefaultMarkdownTheme is the theme of the Markdown documents when none is chosen.
+const DefaultMarkdownTheme = "github"
+
+// markdownTheme is a stylesheet of the Markdown documents, with the style of their code blocks.
+type markdownTheme struct {
+	css string
+	// codeStyle is the name of the chroma style of the code blocks.
+	codeStyle string
+}
+
+// markdownThemes are the themes of the Markdown documents, by name.
+var markdownThemes = map[string]markdownTheme{
+	"github": {codeStyle: "github", css: `
+body { font-family: -apple-system, "Segoe UI", Helvetica, Arial, sans-serif; font-size: 11pt; line-height: 1.5; color: #1f2328 }
+h1, h2 { border-bottom: 1px solid #d1d9e0; padding-bottom: 0.3em }
+h1, h2, h3, h4, h5, h6 { margin: 1.5em 0 0.75em; font-weight: 600; line-height: 1.25; break-after: avoid }
+a { color: #0969da; text-decoration: none }
+code { font-family: ui-monospace, Menlo, Consolas, monospace; font-size: 85%; background: #eff1f3; border-radius: 4px; padding: 0.2em 0.4em }
+pre { background: #f6f8fa; border-radius: 6px; padding: 1em; font-size: 85%; white-space: pre-wrap; break-inside: avoid }
+pre code { background: none; padding: 0; font-size: 100% }
+blockquote { margin: 0; padding: 0 1em; color: #59636e; border-left: 0.25em solid #d1d9e0 }
+table { border-collapse: collapse; break-inside: avoid }
+th, td { border: 1px solid #d1d9e0; padding: 6px 13px }
+tr:nth-child(2n) { background: #f6f8fa }
+img { max-width: 100% }
+hr { border: 0; border-top: 1px solid #d1d9e0 }
+`},
+	"academic": {codeStyle: "friendly", css: `
+body { font-family: Georgia, "Times New Roman", serif; font-size: 12pt; line-height: 1.6; color: #000; text-align: justify; hyphens: auto }
+h1 { text-align: center; font-size: 1.8em }
+h1, h2, h3, h4, h5, h6 { font-weight: normal; margin: 1.5em 0 0.5em; break-after: avoid }
+h2, h3 { font-variant: small-caps }
+a { color: inherit }
+code { font-family: "Courier New", monospace; font-size: 90% }
+pre { border-left: 2px solid #999; padding-left: 1em; white-space: pre-wrap; text-align: left; break-inside: avoid }
+blockquote { margin: 1em 2em; font-style: italic }
+table { border-collapse: collapse; margin: 1em auto; border-top: 2px solid #000; border-bottom: 2px solid #000; break-inside: avoid }
+th { border-bottom: 1px solid #000 }
+th, td { padding: 4px 10px }
+img { max-width: 100% }
+`},
+	"minimal": {codeStyle: "bw", css: `
+body { font-family: Helvetica, Arial, sans-serif; font-size: 10pt; line-height: 1.4 }
+h1, h2, h3, h4, h5, h6 { break-after: avoid }
+pre { white-space: pre-wrap; break-inside: avoid }
+table { border-collapse: collapse }
+th, td { border: 1px solid #999; padding: 2px 6px }
+img { max-width: 100% }
+`},
+}
+
+// MarkdownThemes returns the sorted names of the themes of the Markdown documents.
+func MarkdownThemes() []string {
+	names := make([]string, 0, len(markdownThemes))
+	for name := range markdownThemes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// MarkdownOptions configure how a Markdown document is rendered to HTML.
+type MarkdownOptions struct {
+	// Theme is the name of the stylesheet of the document. It defaults to DefaultMarkdownTheme. See MarkdownThemes.
+	Theme string
+	// BaseURL is the URL the relative links of the document are resolved against, e.g. the directory of a Markdown file.
+	BaseURL string
+	// Safe leaves out the raw HTML of the document, and the links to dangerous URLs such as javascript: ones.
+	// Use it instead of SanitizeHTML, which would remove the classes of the highlighted code blocks.
+	Safe bool
+}
+
+// MarkdownDocument is a Markdown document rendered to HTML.
+type MarkdownDocument struct {
+	HTML []byte
+	// Title and Author come from the front matter of the document.
+	Title  string
+	Author string
+	// Metadata are the other values of the front matter, written as meta tags of the HTML, e.g. {{meta.version}}.
+	Metadata map[string]string
+}
+
+// RenderMarkdown renders a GitHub Flavored Markdown document to a standalone HTML document, with its code blocks highlighted.
+// The document can start with a YAML front matter between --- lines, whose title is the title of the HTML document
+// and whose author and other values are its meta tags.
+func RenderMarkdown(source []byte, opts MarkdownOptions) (MarkdownDocument, error) {
+	var doc MarkdownDocument
+	themeName := opts.Theme
+	if themeName == "" {
+		themeName = DefaultMarkdownTheme
+	}
+	theme, ok := markdownThemes[strings.ToLower(themeName)]
+	if !ok {
+		return doc, &ParamError{Name: "theme", Reason: fmt.Sprintf("%q is not one of %s", themeName, strings.Join(MarkdownThemes(), ", "))}
+	}
+	frontMatter, body := splitFrontMatter(source)
+	metadata, err := parseFrontMatter(frontMatter)
+	if err != nil {
+		return doc, err
+	}
+	doc.Title, doc.Author = metadata["title"], metadata["author"]
+	delete(metadata, "title")
+	doc.Metadata = metadata
+
+	rendererOptions := []renderer.Option{goldmarkhtml.WithXHTML()}
+	if !opts.Safe {
+		rendererOptions = append(rendererOptions, goldmarkhtml.WithUnsafe())
+	}
+	markdown := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			extension.Footnote,
+			highlighting.NewHighlighting(
+				highlighting.WithStyle(theme.codeStyle),
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		),
+		goldmark.WithParserOptions(parser.WithAutoHeadingID()),
+		goldmark.WithRendererOptions(rendererOptions...),
+	)
+	var content bytes.Buffer
+	if err := markdown.Convert(body, &content); err != nil {
+		return doc, fmt.Errorf("could not render Markdown: %v", err)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\">")
+	if opts.BaseURL != "" {
+		fmt.Fprintf(&out, `<base href="%s">`, html.EscapeString(opts.BaseURL))
+	}
+	if doc.Title != "" {
+		fmt.Fprintf(&out, "<title>%s</title>", html.EscapeString(doc.Title))
+	}
+	names := make([]string, 0, len(metadata))
+	for name := range metadata {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&out, `<meta name="%s" content="%s">`, html.EscapeString(name), html.EscapeString(metadata[name]))
+	}
+	out.WriteString("<style>")
+	out.WriteString(theme.css)
+	chromahtml.New(chromahtml.WithClasses(true)).WriteCSS(&out, styles.Get(theme.codeStyle))
+	out.WriteString("</style></head>\n<body><article class=\"markdown-body\">\n")
+	out.Write(content.Bytes())
+	out.WriteString("</article></body></html>\n")
+	doc.HTML = out.Bytes()
+	return doc, nil
+}
+
+// splitFrontMatter splits the YAML front matter between --- lines at the start of a Markdown document from its body.
+func splitFrontMatter(source []byte) (frontMatter, body []byte) {
+	source = bytes.TrimPrefix(source, []byte("\ufeff"))
+	rest, ok := bytes.CutPrefix(source, []byte("---\n"))
+	if !ok {
+		if rest, ok = bytes.CutPrefix(source, []byte("---\r\n")); !ok {
+			return nil, source
+		}
+	}
+	for offset := 0; offset < len(rest); {
+		end := bytes.IndexByte(rest[offset:], '\n')
+		line := rest[offset:]
+		if end >= 0 {
+			line = rest[offset : offset+end+1]
+		}
+		if trimmed := strings.TrimRight(string(line), "\r\n"); trimmed == "---" || trimmed == "..." {
+			return rest[:offset], rest[offset+len(line):]
+		}
+		offset += len(line)
+	}
+	// without a closing line, the document has no front matter
+	return nil, source
+}
+
+// parseFrontMatter returns the values of a YAML front matter as text, by lowercase name.
+// Lists are joined with commas, e.g. for the keywords.
+func parseFrontMatter(frontMatter []byte) (map[string]string, error) {
+	metadata := map[string]string{}
+	if len(bytes.TrimSpace(frontMatter)) == 0 {
+		return metadata, nil
+	}
+	var values map[string]any
+	if err := yaml.Unmarshal(frontMatter, &values); err != nil {
+		return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("invalid front matter: %v", err)}
+	}
+	for name, value := range values {
+		var items []string
+		switch value := value.(type) {
+		case []any:
+			for _, item := range value {
+				items = append(items, frontMatterText(item))
+			}
+		case map[string]any, nil:
+			continue
+		default:
+			items = []string{frontMatterText(value)}
+		}
+		if items = slices.DeleteFunc(items, func(item string) bool { return item == "" }); len(items) > 0 {
+			metadata[strings.ToLower(name)] = strings.Join(items, ", ")
+		}
+	}
+	return metadata, nil
+}
+
+// frontMatterText returns a value of a front matter as text. The dates are written like 2006-01-02.
+func frontMatterText(value any) string {
+	switch value := value.(type) {
+	case time.Time:
+		if value.Hour() == 0 && value.Minute() == 0 && value.Second() == 0 {
+			return value.Format(time.DateOnly)
+		}
+		return value.Format(time.RFC3339)
+	case map[string]any, []any, nil:
+		return ""
+	default:
+		return fmt.Sprint(value)
+	}
+}
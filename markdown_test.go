@@ -0,0 +1,105 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+)
+
+func TestShouldRenderMarkdownDocuments(t *testing.T) {
+	doc, err := RenderMarkdown([]byte(`---
+title: Q1 <Report>
+author: [Jane, John]
+version: 1.2
+---
+# Revenue
+
+| Quarter | Total |
+| ------- | ----- |
+| Q1      | ~~10~~ 12 |
+
+`+"```go\nfunc main() {}\n```\n"), MarkdownOptions{Theme: "Academic", BaseURL: "file:///docs/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Title != "Q1 <Report>" || doc.Author != "Jane, John" || doc.Metadata["version"] != "1.2" {
+		t.Errorf("Expected the front matter to be read, got %+v", doc)
+	}
+	html := string(doc.HTML)
+	for _, expected := range []string{
+		"<title>Q1 &lt;Report&gt;</title>",
+		`<meta name="author" content="Jane, John">`,
+		`<base href="file:///docs/">`,
+		`<h1 id="revenue">Revenue</h1>`,
+		"<table>",
+		"<del>10</del>",
+		`<span class="kd">func</span>`,
+		"Georgia",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML to contain %q, got %s", expected, html)
+		}
+	}
+	if metadata := DocumentMetadata(doc.HTML); metadata["title"] != "Q1 <Report>" || metadata["version"] != "1.2" {
+		t.Errorf("Expected the front matter to be available to the templates, got %v", metadata)
+	}
+}
+
+func TestShouldDropRawHTMLFromSafeMarkdown(t *testing.T) {
+	source := []byte("Hello <script>alert(1)</script> World\n")
+	doc, err := RenderMarkdown(source, MarkdownOptions{Safe: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(doc.HTML, []byte("<script>")) {
+		t.Errorf("Expected the raw HTML to be dropped, got %s", doc.HTML)
+	}
+	if doc, _ := RenderMarkdown(source, MarkdownOptions{}); !bytes.Contains(doc.HTML, []byte("<script>alert(1)</script>")) {
+		t.Errorf("Expected the raw HTML to be kept, got %s", doc.HTML)
+	}
+}
+
+func TestShouldRejectInvalidMarkdownDocuments(t *testing.T) {
+	for name, tc := range map[string]struct {
+		source string
+		opts   MarkdownOptions
+	}{
+		"theme": {"# Hello", MarkdownOptions{Theme: "neon"}},
+		"body":  {"---\ntitle: [unclosed\n---\n# Hello", MarkdownOptions{}},
+	} {
+		_, err := RenderMarkdown([]byte(tc.source), tc.opts)
+		if errs := paramErrors(err); len(errs) != 1 || errs[0].Name != name {
+			t.Errorf("Expected an invalid %s, got %v", name, err)
+		}
+	}
+
+	s := newServer(DefaultConfig())
+	r := newConvertRequest(t, "/convert?theme=neon", "# Hello")
+	r.Header.Set("Content-Type", "text/markdown; charset=utf-8")
+	w := httptest.NewRecorder()
+	s.handleConvert(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400 for an unknown theme, got %d", w.Code)
+	}
+}
+
+func TestShouldSetTheAuthorOfPDFs(t *testing.T) {
+	content, err := setProperties(newTestPDF("Hello World"), map[string]string{"Author": "Jane Doe"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	ctx, err := api.ReadValidateAndOptimize(bytes.NewReader(content), conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ctx.Author != "Jane Doe" {
+		t.Errorf("Expected the author to be Jane Doe, got %q", ctx.Author)
+	}
+}
@@ -0,0 +1,162 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Outcomes of a conversion, as reported by the lazypress_conversions_total metric.
+const (
+	outcomeSuccess        = "success"
+	outcomeInvalidRequest = "invalid_request"
+	outcomeRenderError    = "render_error"
+	outcomeExportError    = "export_error"
+	outcomeRejected       = "rejected"
+	outcomeLimitExceeded  = "limit_exceeded"
+)
+
+// Phases of a conversion, as reported by the lazypress_render_phase_duration_seconds metric.
+const (
+	phaseLoad   = "load"
+	phasePrint  = "print"
+	phaseExport = "export"
+)
+
+type lazypressMetrics struct {
+	registry *prometheus.Registry
+
+	conversions    *prometheus.CounterVec
+	phaseDuration  *prometheus.HistogramVec
+	pdfSize        prometheus.Histogram
+	pdfPages       prometheus.Histogram
+	sanitizations  prometheus.Counter
+	sanitizedBytes prometheus.Counter
+	rendersActive  prometheus.Gauge
+	queueWaiting   *prometheus.GaugeVec
+	queueRejects   *prometheus.CounterVec
+	chromeStarts   prometheus.Counter
+	cacheRequests  *prometheus.CounterVec
+}
+
+var metrics = newMetrics()
+
+func newMetrics() *lazypressMetrics {
+	m := &lazypressMetrics{
+		registry: prometheus.NewRegistry(),
+		conversions: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lazypress_conversions_total",
+			Help: "Number of conversions handled, by outcome and output type.",
+		}, []string{"outcome", "output"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "lazypress_render_phase_duration_seconds",
+			Help:    "Time spent in each phase of a conversion: load, print and export.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 2, 14),
+		}, []string{"phase"}),
+		pdfSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "lazypress_pdf_size_bytes",
+			Help:    "Size of the generated PDFs.",
+			Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+		}),
+		pdfPages: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "lazypress_pdf_pages",
+			Help:    "Number of pages of the generated PDFs.",
+			Buckets: []float64{1, 2, 5, 10, 20, 50, 100, 200, 500, 1000},
+		}),
+		sanitizations: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "lazypress_sanitization_removals_total",
+			Help: "Number of sanitized documents from which content was removed.",
+		}),
+		sanitizedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "lazypress_sanitization_removed_bytes_total",
+			Help: "Number of bytes removed by the sanitizer.",
+		}),
+		rendersActive: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "lazypress_renders_active",
+			Help: "Number of renders in progress, not counting the conversions waiting in the queue.",
+		}),
+		queueWaiting: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "lazypress_queue_waiting",
+			Help: "Number of conversions waiting for a render, by priority.",
+		}, []string{"priority"}),
+		queueRejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lazypress_queue_rejections_total",
+			Help: "Number of conversions rejected by the render queue, by reason: full or timeout.",
+		}, []string{"reason"}),
+		chromeStarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "lazypress_chrome_starts_total",
+			Help: "Number of times a Chrome process was started.",
+		}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "lazypress_cache_requests_total",
+			Help: "Number of conversions looked up in the render cache, by result: hit, miss or bypass.",
+		}, []string{"result"}),
+	}
+	m.registry.MustRegister(
+		m.conversions,
+		m.phaseDuration,
+		m.pdfSize,
+		m.pdfPages,
+		m.sanitizations,
+		m.sanitizedBytes,
+		m.rendersActive,
+		m.queueWaiting,
+		m.queueRejects,
+		m.chromeStarts,
+		m.cacheRequests,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// MetricsHandler returns an http.Handler exposing the lazypress metrics in the Prometheus format.
+// The server serves it at /metrics.
+func MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{})
+}
+
+func (m *lazypressMetrics) observePhase(phase string, start time.Time) {
+	m.phaseDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+}
+
+func (m *lazypressMetrics) observePDF(content []byte) {
+	m.pdfSize.Observe(float64(len(content)))
+	m.pdfPages.Observe(float64(countPages(content)))
+}
+
+func (m *lazypressMetrics) observeSanitization(before, after int) {
+	if after < before {
+		m.sanitizations.Inc()
+		m.sanitizedBytes.Add(float64(before - after))
+	}
+}
+
+func (m *lazypressMetrics) conversion(outcome, output string) {
+	output = strings.ToLower(output)
+	if output == "" {
+		output = "download"
+	}
+	// the output comes from the request, so only the names of the exporters are used, and clients cannot create series at will
+	if _, ok := lookupExporter(output); !ok {
+		output = "invalid"
+	}
+	m.conversions.WithLabelValues(outcome, output).Inc()
+}
+
+// pageObject matches the page objects of a PDF, but not the /Pages tree nodes.
+var pageObject = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// countPages returns the number of pages of a PDF generated by Chrome.
+func countPages(content []byte) int {
+	if !bytes.HasPrefix(content, []byte("%PDF")) {
+		return 0
+	}
+	return len(pageObject.FindAll(content, -1))
+}
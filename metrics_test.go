@@ -0,0 +1,122 @@
+﻿package lazypress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestShouldCountPDFPages(t *testing.T) {
+	pdf := []byte("%PDF-1.4\n1 0 obj << /Type /Pages /Count 2 >>\n2 0 obj << /Type /Page >>\n3 0 obj << /Type/Page /Parent 1 0 R >>\n")
+	if pages := countPages(pdf); pages != 2 {
+		t.Errorf("Expected 2 pages, got %d", pages)
+	}
+	if pages := countPages([]byte("<html></html>")); pages != 0 {
+		t.Errorf("Expected 0 pages for content that is not a PDF, got %d", pages)
+	}
+}
+
+func TestShouldCountInvalidConversions(t *testing.T) {
+	counter := metrics.conversions.WithLabelValues(outcomeInvalidRequest, "file")
+	before := testutil.ToFloat64(counter)
+	html := `<html><body>Hello World</body></html>`
+	req, err := http.NewRequest("POST", "/convert?output=file", strings.NewReader(html))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", fmt.Sprint((len(html))))
+	w := httptest.NewRecorder()
+	NewServer(0, "").handleConvert(w, req)
+	if after := testutil.ToFloat64(counter); after != before+1 {
+		t.Errorf("Expected invalid conversions to be %v, got %v", before+1, after)
+	}
+}
+
+func TestShouldCountSanitizationRemovals(t *testing.T) {
+	before := testutil.ToFloat64(metrics.sanitizations)
+	metrics.observeSanitization(10, 10)
+	metrics.observeSanitization(10, 4)
+	if after := testutil.ToFloat64(metrics.sanitizations); after != before+1 {
+		t.Errorf("Expected sanitization removals to be %v, got %v", before+1, after)
+	}
+}
+
+func TestShouldExposeMetrics(t *testing.T) {
+	s := NewServer(0, "")
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	result := w.Result()
+	defer result.Body.Close()
+	body, err := io.ReadAll(result.Body)
+	if err != nil {
+		t.Error(err)
+	}
+	if !strings.Contains(string(body), "lazypress_renders_active") {
+		t.Error("Expected metrics to contain lazypress_renders_active")
+	}
+}
+
+func TestShouldNotLabelConversionsWithUnknownOutputs(t *testing.T) {
+	invalid := metrics.conversions.WithLabelValues(outcomeInvalidRequest, "invalid")
+	before := testutil.ToFloat64(invalid)
+	w := httptest.NewRecorder()
+	NewServer(0, "").handleConvert(w, newConvertRequest(t, "/convert?output=series-"+newRequestID(), "<html></html>"))
+	if after := testutil.ToFloat64(invalid); after != before+1 {
+		t.Errorf("Expected the conversion to be counted with an invalid output, got %v", after-before)
+	}
+	metrics.conversion(outcomeSuccess, "FILE")
+	if testutil.ToFloat64(metrics.conversions.WithLabelValues(outcomeSuccess, "file")) == 0 {
+		t.Error("Expected the registered outputs to be kept, ignoring the case")
+	}
+}
+
+func TestShouldCountWaitingConversionsAndActiveRendersApart(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Queue.MaxConcurrent = 1
+	cfg.Queue.MaxWaiting = 1
+	s := newServer(cfg)
+	waiting := metrics.queueWaiting.WithLabelValues(priorityInteractive)
+	waitingBefore, activeBefore := testutil.ToFloat64(waiting), testutil.ToFloat64(metrics.rendersActive)
+
+	release, err := s.waitForRender(context.Background(), map[string]string{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	done, err := s.trackRender(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acquired := make(chan struct{})
+	go func() {
+		if release, err := s.waitForRender(context.Background(), map[string]string{}); err == nil {
+			release()
+		}
+		close(acquired)
+	}()
+	waitForWaiting(t, s.queue, 1)
+
+	if got := testutil.ToFloat64(metrics.rendersActive) - activeBefore; got != 1 {
+		t.Errorf("Expected 1 active render, got %v", got)
+	}
+	if got := testutil.ToFloat64(waiting) - waitingBefore; got != 1 {
+		t.Errorf("Expected 1 waiting conversion, got %v", got)
+	}
+
+	done()
+	release()
+	<-acquired
+	if got := testutil.ToFloat64(metrics.rendersActive) - activeBefore; got != 0 {
+		t.Errorf("Expected no active render once it is over, got %v", got)
+	}
+	if got := testutil.ToFloat64(waiting) - waitingBefore; got != 0 {
+		t.Errorf("Expected nothing waiting once the render is handed over, got %v", got)
+	}
+}
@@ -0,0 +1,184 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// MHTMLContentType is the content type of the MHTML archives, a web page saved with its resources.
+const MHTMLContentType = "multipart/related"
+
+// cssURLPattern matches the url() references of a stylesheet.
+var cssURLPattern = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+
+// resourceResolver returns the data URL of the resource at a URL, relative to the URL of the document
+// referencing it, and false when there is no such resource.
+type resourceResolver func(ref, base string) (string, bool)
+
+// RenderMHTML renders an MHTML archive (RFC 2557), e.g. a page saved by Chrome, to a standalone HTML document,
+// its stylesheets, images and fonts embedded as data URLs so that the page does not load anything.
+// The archive is either a whole MIME message, with its headers, or the body of a multipart/related
+// message whose content type, with its boundary, is given.
+func RenderMHTML(archive []byte, contentType string) ([]byte, error) {
+	header := textproto.MIMEHeader{"Content-Type": {contentType}}
+	var body io.Reader = bytes.NewReader(archive)
+	if msg, err := mail.ReadMessage(bytes.NewReader(archive)); err == nil {
+		if mediaType, _, _ := mime.ParseMediaType(msg.Header.Get("Content-Type")); mediaType == MHTMLContentType {
+			header, body = textproto.MIMEHeader(msg.Header), msg.Body
+		}
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || mediaType != MHTMLContentType || params["boundary"] == "" {
+		return nil, &ParamError{Name: "body", Reason: "not a multipart/related archive with a boundary"}
+	}
+	parts, err := readMIMEParts(header, body)
+	if err != nil {
+		return nil, err
+	}
+
+	root := -1
+	for i, part := range parts {
+		if params["start"] != "" && part.contentID() != strings.Trim(params["start"], "<>") {
+			continue
+		}
+		if part.mediaType == "text/html" {
+			root = i
+			break
+		}
+	}
+	if root < 0 {
+		return nil, &ParamError{Name: "body", Reason: "the archive has no HTML document"}
+	}
+
+	resources := map[string]string{}
+	addResource := func(part mimePart, dataURL string) {
+		if location := part.header.Get("Content-Location"); location != "" {
+			resources[location] = dataURL
+		}
+		if id := part.contentID(); id != "" {
+			resources["cid:"+id] = dataURL
+		}
+	}
+	resolve := func(ref, base string) (string, bool) {
+		ref = strings.TrimSpace(ref)
+		if dataURL, ok := resources[ref]; ok {
+			return dataURL, true
+		}
+		baseURL, err := url.Parse(base)
+		if err != nil {
+			return "", false
+		}
+		refURL, err := url.Parse(ref)
+		if err != nil {
+			return "", false
+		}
+		dataURL, ok := resources[baseURL.ResolveReference(refURL).String()]
+		return dataURL, ok
+	}
+	// the stylesheets reference the images and fonts, and the frames reference all of them,
+	// so they are embedded after the resources they reference
+	pass := func(part mimePart) int {
+		return slices.Index([]string{"text/css", "text/html"}, part.mediaType) + 1
+	}
+	for current := range 3 {
+		for i, part := range parts {
+			if i == root || pass(part) != current {
+				continue
+			}
+			content := part.content
+			switch part.mediaType {
+			case "text/css":
+				content = []byte(rewriteCSS(string(content), part.header.Get("Content-Location"), resolve))
+			case "text/html":
+				if content, err = rewriteHTML(content, part.header.Get("Content-Location"), resolve); err != nil {
+					return nil, err
+				}
+			}
+			addResource(part, dataURL(part.mediaType, content))
+		}
+	}
+	return rewriteHTML(parts[root].content, parts[root].header.Get("Content-Location"), resolve)
+}
+
+// dataURL returns the content as a data URL.
+func dataURL(mediaType string, content []byte) string {
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	return fmt.Sprintf("data:%s;base64,%s", mediaType, base64.StdEncoding.EncodeToString(content))
+}
+
+// rewriteHTML replaces the references of an HTML document to the resources with their data URLs.
+func rewriteHTML(content []byte, base string, resolve resourceResolver) ([]byte, error) {
+	doc, err := html.Parse(bytes.NewReader(content))
+	if err != nil {
+		return nil, &ParamError{Name: "body", Reason: fmt.Sprintf("invalid HTML: %v", err)}
+	}
+	rewriteNode(doc, base, resolve)
+	var out bytes.Buffer
+	if err := html.Render(&out, doc); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// rewriteNode replaces the references to the resources in the attributes and style elements of the node and its children.
+func rewriteNode(n *html.Node, base string, resolve resourceResolver) {
+	if n.Type == html.ElementNode {
+		for i, attr := range n.Attr {
+			switch {
+			case attr.Key == "src" || attr.Key == "background" || attr.Key == "poster" || (attr.Key == "href" && n.Data == "link"):
+				if dataURL, ok := resolve(attr.Val, base); ok {
+					n.Attr[i].Val = dataURL
+				}
+			case attr.Key == "srcset":
+				candidates := strings.Split(attr.Val, ",")
+				for j, candidate := range candidates {
+					fields := strings.Fields(candidate)
+					if len(fields) == 0 {
+						continue
+					}
+					if dataURL, ok := resolve(fields[0], base); ok {
+						fields[0] = dataURL
+					}
+					candidates[j] = strings.Join(fields, " ")
+				}
+				n.Attr[i].Val = strings.Join(candidates, ", ")
+			case attr.Key == "style":
+				n.Attr[i].Val = rewriteCSS(attr.Val, base, resolve)
+			}
+		}
+		if n.Data == "style" {
+			for child := n.FirstChild; child != nil; child = child.NextSibling {
+				if child.Type == html.TextNode {
+					child.Data = rewriteCSS(child.Data, base, resolve)
+				}
+			}
+		}
+	}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		rewriteNode(child, base, resolve)
+	}
+}
+
+// rewriteCSS replaces the url() references of a stylesheet to the resources with their data URLs.
+func rewriteCSS(css, base string, resolve resourceResolver) string {
+	return cssURLPattern.ReplaceAllStringFunc(css, func(match string) string {
+		ref := cssURLPattern.FindStringSubmatch(match)[2]
+		if dataURL, ok := resolve(ref, base); ok {
+			return `url("` + dataURL + `")`
+		}
+		return match
+	})
+}
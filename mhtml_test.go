@@ -0,0 +1,72 @@
+﻿package lazypress
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMHTML = "From: <Saved by Blink>\r\n" +
+	"Subject: Report\r\n" +
+	"MIME-Version: 1.0\r\n" +
+	"Content-Type: multipart/related; type=\"text/html\"; boundary=\"----MultipartBoundary\"\r\n" +
+	"\r\n" +
+	"------MultipartBoundary\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"Content-Location: https://example.com/reports/q1.html\r\n" +
+	"\r\n" +
+	"<html><head><link rel=3D\"stylesheet\" href=3D\"style.css\"></head><body style=3D\"background: url(/bg.png)\">" +
+	"<img src=3D\"https://example.com/logo.png\" srcset=3D\"/logo.png 1x, missing.png 2x\"><a href=3D\"/logo.png\">logo</a></body></html>\r\n" +
+	"------MultipartBoundary\r\n" +
+	"Content-Type: text/css\r\n" +
+	"Content-Location: https://example.com/reports/style.css\r\n" +
+	"\r\n" +
+	"h1 { background: url('../logo.png') }\r\n" +
+	"------MultipartBoundary\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Location: https://example.com/logo.png\r\n" +
+	"\r\n" +
+	"iVBORw0KGgo=\r\n" +
+	"------MultipartBoundary\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"Content-Location: https://example.com/bg.png\r\n" +
+	"\r\n" +
+	"AAAA\r\n" +
+	"------MultipartBoundary--\r\n"
+
+func TestShouldRenderMHTMLArchives(t *testing.T) {
+	content, err := RenderMHTML([]byte(testMHTML), MHTMLContentType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(content)
+	logo := "data:image/png;base64,iVBORw0KGgo="
+	for _, expected := range []string{
+		`<img src="` + logo + `" srcset="` + logo + ` 1x, missing.png 2x"/>`,
+		`style="background: url(&#34;data:image/png;base64,AAAA&#34;)"`,
+		`<a href="/logo.png">`,
+		`<link rel="stylesheet" href="data:text/css;base64,`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML to contain %q, got %s", expected, html)
+		}
+	}
+	if css := rewriteCSS("h1 { background: url('../logo.png') }", "https://example.com/reports/style.css", func(ref, base string) (string, bool) {
+		return ref + " from " + base, true
+	}); css != `h1 { background: url("../logo.png from https://example.com/reports/style.css") }` {
+		t.Errorf("Expected the URLs of the stylesheet to be rewritten, got %s", css)
+	}
+
+	// the body of a multipart/related request, without the headers of the archive
+	_, body, _ := strings.Cut(testMHTML, "\r\n\r\n")
+	if _, err := RenderMHTML([]byte(body), `multipart/related; boundary="----MultipartBoundary"`); err != nil {
+		t.Errorf("Expected the archive to be read with the boundary of the request, got %v", err)
+	}
+	for _, contentType := range []string{MHTMLContentType, "multipart/related; boundary=other"} {
+		if _, err := RenderMHTML([]byte(body), contentType); len(paramErrors(err)) != 1 {
+			t.Errorf("Expected the archive to be invalid with %s, got %v", contentType, err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"html"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/chromedp"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// HeaderFooter is a pair of header and footer templates, written like Settings.HeaderTemplate.
+type HeaderFooter struct {
+	Header string
+	Footer string
+}
+
+// blankTemplate is a template printing nothing, as Chrome prints its default header and footer for empty ones.
+const blankTemplate = "<span></span>"
+
+// PageTemplates are the header and footer templates of some pages, replacing the ones of Settings.
+// In each HeaderFooter, an empty template keeps the one of Settings; use "<span></span>" to print nothing.
+// The first page takes its templates from First, if set, and the other pages from Odd or Even, if set.
+//
+// Chrome prints the same header and footer on every page, so the document is printed without them.
+// Each set of templates is then printed on its own, over blank pages, and stamped onto the pages it belongs to.
+// Chrome's url class shows the address of the blank pages, and the page size must not come from CSS.
+type PageTemplates struct {
+	First *HeaderFooter
+	Odd   *HeaderFooter
+	Even  *HeaderFooter
+}
+
+// enabled reports whether some pages have their own templates.
+func (t PageTemplates) enabled() bool {
+	return t.First != nil || t.Odd != nil || t.Even != nil
+}
+
+// pageTemplateParams are the parameters of the PageTemplates, with the field they set.
+var pageTemplateParams = []struct {
+	name   string
+	header bool
+	field  func(*PageTemplates) **HeaderFooter
+}{
+	{"firstHeaderTemplate", true, func(t *PageTemplates) **HeaderFooter { return &t.First }},
+	{"firstFooterTemplate", false, func(t *PageTemplates) **HeaderFooter { return &t.First }},
+	{"oddHeaderTemplate", true, func(t *PageTemplates) **HeaderFooter { return &t.Odd }},
+	{"oddFooterTemplate", false, func(t *PageTemplates) **HeaderFooter { return &t.Odd }},
+	{"evenHeaderTemplate", true, func(t *PageTemplates) **HeaderFooter { return &t.Even }},
+	{"evenFooterTemplate", false, func(t *PageTemplates) **HeaderFooter { return &t.Even }},
+}
+
+// setTemplate sets the template of a page template parameter. An empty template prints nothing.
+func (t *PageTemplates) setTemplate(param, template string) {
+	if template == "" {
+		template = blankTemplate
+	}
+	for _, p := range pageTemplateParams {
+		if p.name != param {
+			continue
+		}
+		field := p.field(t)
+		if *field == nil {
+			*field = &HeaderFooter{}
+		}
+		if p.header {
+			(*field).Header = template
+		} else {
+			(*field).Footer = template
+		}
+	}
+}
+
+// each calls fn with a pointer to each template set, so that it can be changed.
+func (t *PageTemplates) each(fn func(template *string)) {
+	for _, hf := range []*HeaderFooter{t.First, t.Odd, t.Even} {
+		if hf != nil {
+			fn(&hf.Header)
+			fn(&hf.Footer)
+		}
+	}
+}
+
+// templatesForPage returns the templates of a page, numbered from 1.
+func (p *PDF) templatesForPage(page int) HeaderFooter {
+	templates := HeaderFooter{Header: blankTemplate, Footer: blankTemplate}
+	if p.Settings.DisplayHeaderFooter {
+		templates = HeaderFooter{Header: p.Settings.HeaderTemplate, Footer: p.Settings.FooterTemplate}
+	}
+	variant := p.PageTemplates.Even
+	if page%2 == 1 {
+		variant = p.PageTemplates.Odd
+	}
+	if page == 1 && p.PageTemplates.First != nil {
+		variant = p.PageTemplates.First
+	}
+	if variant != nil && variant.Header != "" {
+		templates.Header = variant.Header
+	}
+	if variant != nil && variant.Footer != "" {
+		templates.Footer = variant.Footer
+	}
+	return templates
+}
+
+// stampPageTemplates prints the headers and footers of the pages of the PDF, and stamps them onto its pages.
+func (p *PDF) stampPageTemplates(ctx context.Context, content []byte) ([]byte, error) {
+	pages := countPages(content)
+	groups := map[HeaderFooter][]int{}
+	var order []HeaderFooter
+	for page := 1; page <= pages; page++ {
+		templates := p.templatesForPage(page)
+		if templates == (HeaderFooter{Header: blankTemplate, Footer: blankTemplate}) {
+			continue
+		}
+		if _, ok := groups[templates]; !ok {
+			order = append(order, templates)
+		}
+		groups[templates] = append(groups[templates], page)
+	}
+	for _, templates := range order {
+		overlay, err := p.printOverlay(ctx, templates, pages)
+		if err != nil {
+			return nil, err
+		}
+		if content, err = stampPages(content, overlay, groups[templates]); err != nil {
+			return nil, err
+		}
+	}
+	return content, nil
+}
+
+// printOverlay prints the header and footer templates over the given number of blank pages, in a new tab.
+func (p *PDF) printOverlay(ctx context.Context, templates HeaderFooter, pages int) ([]byte, error) {
+	var document strings.Builder
+	document.WriteString("<!DOCTYPE html><html><head><title>")
+	document.WriteString(html.EscapeString(p.TemplateData.Metadata["title"]))
+	document.WriteString("</title><style>html, body { margin: 0 } div { height: 1px; break-after: page } div:last-child { break-after: auto }</style></head><body>")
+	document.WriteString(strings.Repeat("<div></div>", pages))
+	document.WriteString("</body></html>")
+
+	file, err := os.CreateTemp("", "lazypress*.html")
+	if err != nil {
+		return nil, fmt.Errorf("could not create temporary file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.WriteString(document.String())
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("could not write temporary file: %v", err)
+	}
+
+	tabCtx, cancel := chromedp.NewContext(ctx)
+	defer cancel()
+	settings := p.Settings
+	settings.DisplayHeaderFooter = true
+	settings.HeaderTemplate = templates.Header
+	settings.FooterTemplate = templates.Footer
+	settings.PageRanges = ""
+	settings.PreferCSSPageSize = false
+	settings.TransferMode = ""
+	var overlay []byte
+	err = chromedp.Run(tabCtx, loadURLInBrowser("file://"+file.Name()), chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		overlay, _, err = settings.Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, limitError(tabCtx, "could not print headers and footers", err)
+	}
+	return overlay, nil
+}
+
+var disablePDFConfigDir sync.Once
+
+// pdfConfig returns the pdfcpu configuration to read the PDFs printed by Chrome, with a relaxed validation.
+// pdfcpu would otherwise write its configuration in the user's config directory when it is first built,
+// so that is turned off before.
+func pdfConfig() *model.Configuration {
+	disablePDFConfigDir.Do(api.DisableConfigDir)
+	conf := model.NewDefaultConfiguration()
+	conf.ValidationMode = model.ValidationRelaxed
+	return conf
+}
+
+// stampPages stamps the pages of overlay onto the same pages of content, for the given page numbers.
+func stampPages(content, overlay []byte, pages []int) ([]byte, error) {
+	conf := pdfConfig()
+	wm, err := api.PDFMultiWatermarkForReadSeeker(bytes.NewReader(overlay), 1, 1, "scale:1 abs, rotation:0", true, false, types.POINTS)
+	if err != nil {
+		return nil, fmt.Errorf("could not stamp headers and footers: %v", err)
+	}
+	selected := make([]string, 0, len(pages))
+	for _, page := range slices.Sorted(slices.Values(pages)) {
+		selected = append(selected, strconv.Itoa(page))
+	}
+	var out bytes.Buffer
+	if err := api.AddWatermarks(bytes.NewReader(content), &out, selected, wm, conf); err != nil {
+		return nil, fmt.Errorf("could not stamp headers and footers: %v", err)
+	}
+	return out.Bytes(), nil
+}
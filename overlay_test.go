@@ -0,0 +1,162 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/chromedp/cdproto/page"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// newTestPDF returns a Letter PDF with a page for each text.
+func newTestPDF(texts ...string) []byte {
+	return newTestPDFWithCatalog("", texts...)
+}
+
+// newTestPDFWithCatalog is like newTestPDF, with more entries in its catalog.
+// The page objects are numbered 4, 6, 8, etc.
+func newTestPDFWithCatalog(entries string, texts ...string) []byte {
+	var objects []string
+	kids := make([]string, len(texts))
+	for i, text := range texts {
+		pageObj, contentObj := 4+2*i, 5+2*i
+		kids[i] = fmt.Sprintf("%d 0 R", pageObj)
+		stream := fmt.Sprintf("BT /F1 12 Tf 72 720 Td (%s) Tj ET", text)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", contentObj),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(stream), stream),
+		)
+	}
+	objects = append([]string{
+		fmt.Sprintf("<< /Type /Catalog /Pages 2 0 R %s>>", entries),
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(texts)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>",
+	}, objects...)
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return pdf.Bytes()
+}
+
+func TestShouldStampOverlaysOntoTheSelectedPages(t *testing.T) {
+	content := newTestPDF("one", "two", "three")
+	overlay := newTestPDF("footer 1", "footer 2", "footer 3")
+	stamped, err := stampPages(content, overlay, []int{3, 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if actual := stampedPages(t, stamped); !reflect.DeepEqual(actual, []bool{true, false, true}) {
+		t.Errorf("Expected pages 1 and 3 to be stamped, got %v", actual)
+	}
+}
+
+// stampedPages reports, for each page of the PDF, whether it has an XObject, which is how overlays are stamped.
+func stampedPages(t *testing.T, content []byte) []bool {
+	t.Helper()
+	ctx, err := api.ReadContext(bytes.NewReader(content), model.NewDefaultConfiguration())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ctx.EnsurePageCount(); err != nil {
+		t.Fatal(err)
+	}
+	stamped := make([]bool, ctx.PageCount)
+	for pageNr := 1; pageNr <= ctx.PageCount; pageNr++ {
+		pageDict, _, _, err := ctx.PageDict(pageNr, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resources, err := ctx.DereferenceDict(pageDict["Resources"])
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, stamped[pageNr-1] = resources["XObject"].(types.Dict)
+		if ir, ok := resources["XObject"].(types.IndirectRef); ok {
+			_, stamped[pageNr-1] = ctx.XRefTable.Find(int(ir.ObjectNumber))
+		}
+	}
+	return stamped
+}
+
+func TestShouldStampThePageTemplatesOfARender(t *testing.T) {
+	if _, err := (&Server{}).resolveChromePath(); err != nil {
+		t.Skip("chrome is not available:", err)
+	}
+	var p PDF
+	err := p.LoadSettings(map[string]string{"displayHeaderFooter": "true", "firstHeaderTemplate": "<span>Cover</span>"}, io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := []byte(`<html><body><p style="break-after: page">one</p><p style="break-after: page">two</p><p>three</p></body></html>`)
+	if err := p.Render(context.Background(), html); err != nil {
+		t.Fatal(err)
+	}
+	if actual := stampedPages(t, p.Content); !reflect.DeepEqual(actual, []bool{true, false, false}) {
+		t.Errorf("Expected only the first page to be stamped, got %v", actual)
+	}
+}
+
+func TestShouldChooseTheTemplatesOfEachPage(t *testing.T) {
+	var p PDF
+	err := p.LoadSettings(map[string]string{
+		"displayHeaderFooter": "true",
+		"headerTemplate":      "<span>header</span>",
+		"footerTemplate":      "<span>footer</span>",
+		"firstHeaderTemplate": "",
+		"oddFooterTemplate":   "<span>odd</span>",
+		"evenFooterTemplate":  "<span>even</span>",
+	}, io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pageNr, expected := range map[int]HeaderFooter{
+		1: {blankTemplate, "<span>footer</span>"},
+		2: {"<span>header</span>", "<span>even</span>"},
+		3: {"<span>header</span>", "<span>odd</span>"},
+	} {
+		if actual := p.templatesForPage(pageNr); actual != expected {
+			t.Errorf("Expected the templates of page %d to be %+v, got %+v", pageNr, expected, actual)
+		}
+	}
+
+	p = PDF{PageTemplates: PageTemplates{First: &HeaderFooter{Header: "<span>Dear {{var.name}}</span>"}}}
+	p.TemplateData.Vars = map[string]string{"name": "Jane"}
+	p.ExpandTemplates(nil)
+	if actual := p.templatesForPage(1); actual.Header != "<span>Dear Jane</span>" || actual.Footer != blankTemplate {
+		t.Errorf("Expected the first page to have the expanded header only, got %+v", actual)
+	}
+	if actual := p.templatesForPage(2); actual != (HeaderFooter{blankTemplate, blankTemplate}) {
+		t.Errorf("Expected the other pages to have no header and footer, got %+v", actual)
+	}
+}
+
+func TestShouldNotStreamPDFsWithPageTemplates(t *testing.T) {
+	p := PDF{Stream: true, PageTemplates: PageTemplates{Even: &HeaderFooter{Footer: "<span>even</span>"}}}
+	if p.streaming() {
+		t.Error("Expected a PDF with page templates not to be streamed")
+	}
+	key := RenderKey(nil, p.renderAssets(), page.PrintToPDFParams{})
+	p.PageTemplates.Even.Footer = "<span>odd</span>"
+	if RenderKey(nil, p.renderAssets(), page.PrintToPDFParams{}) == key {
+		t.Error("Expected the page templates to change the render key")
+	}
+}
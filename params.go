@@ -0,0 +1,290 @@
+﻿package lazypress
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/page"
+)
+
+// otherParams are the parameters understood by lazypress on top of the PrintToPDFParams settings.
+var otherParams = []string{"output", "filename", "sanitize", "profile", "format", "margin", "lenient", "stream", "cache", "priority", "queueTimeout", "headerFile", "footerFile", "timezone", "locale",
+	"firstHeaderTemplate", "firstFooterTemplate", "oddHeaderTemplate", "oddFooterTemplate", "evenHeaderTemplate", "evenFooterTemplate",
+	"firstHeaderFile", "firstFooterFile", "oddHeaderFile", "oddFooterFile", "evenHeaderFile", "evenFooterFile",
+	"toc", "tocTitle", "tocLevels", "tocTemplate", "tocFile", "info", "theme", "lineNumbers"}
+
+// varParamPrefix is the prefix of the parameters holding the custom values of the templates, e.g. var.client.
+const varParamPrefix = "var."
+
+// lengthParams are the settings accepting a length with a unit (see ParseLength).
+var lengthParams = []string{"paperWidth", "paperHeight", "marginTop", "marginRight", "marginBottom", "marginLeft"}
+
+// ParamError reports an invalid parameter.
+type ParamError struct {
+	Name   string
+	Reason string
+}
+
+func (e *ParamError) Error() string {
+	return e.Name + ": " + e.Reason
+}
+
+// paramErrors returns the ParamErrors in err, which may join several errors.
+func paramErrors(err error) []*ParamError {
+	var paramErr *ParamError
+	if errors.As(err, &paramErr) {
+		if joined, ok := err.(interface{ Unwrap() []error }); ok {
+			var errs []*ParamError
+			for _, err := range joined.Unwrap() {
+				errs = append(errs, paramErrors(err)...)
+			}
+			return errs
+		}
+		return []*ParamError{paramErr}
+	}
+	return nil
+}
+
+// paperFormats are the named paper sizes accepted by the format parameter, as width and height.
+var paperFormats = map[string][2]string{
+	"a3":      {"297mm", "420mm"},
+	"a4":      {"210mm", "297mm"},
+	"a5":      {"148mm", "210mm"},
+	"letter":  {"8.5in", "11in"},
+	"legal":   {"8.5in", "14in"},
+	"tabloid": {"11in", "17in"},
+}
+
+// normalizeParams returns the parameters with their canonical keys, ignoring the case (e.g. PaperWidth becomes paperWidth).
+// It expands the format and margin shorthands, and converts the lengths to inches.
+// The settings given explicitly take precedence over the shorthands, e.g. marginTop over margin.
+// Unknown parameters are reported as errors.
+func normalizeParams(params map[string]string) (map[string]string, error) {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var errs []error
+	normalized := make(map[string]string, len(params))
+	for _, k := range keys {
+		if name, ok := cutPrefixFold(k, varParamPrefix); ok && name != "" {
+			// the names of the custom values keep their case
+			normalized[varParamPrefix+name] = params[k]
+			continue
+		}
+		key := settingKey(k)
+		if _, ok := lookupSettingKey(key); !ok && !slices.Contains(otherParams, key) {
+			errs = append(errs, &ParamError{Name: k, Reason: "unknown parameter"})
+			continue
+		}
+		normalized[key] = params[k]
+	}
+
+	if format, ok := normalized["format"]; ok {
+		size, ok := paperFormats[strings.ToLower(strings.TrimSpace(format))]
+		if ok {
+			setDefault(normalized, "paperWidth", size[0])
+			setDefault(normalized, "paperHeight", size[1])
+		} else {
+			errs = append(errs, &ParamError{Name: "format", Reason: fmt.Sprintf("unknown paper format %q", format)})
+		}
+		delete(normalized, "format")
+	}
+	if margin, ok := normalized["margin"]; ok {
+		top, right, bottom, left, err := ParseMargins(margin)
+		if err != nil {
+			errs = append(errs, &ParamError{Name: "margin", Reason: err.Error()})
+		} else {
+			setDefault(normalized, "marginTop", formatInches(top))
+			setDefault(normalized, "marginRight", formatInches(right))
+			setDefault(normalized, "marginBottom", formatInches(bottom))
+			setDefault(normalized, "marginLeft", formatInches(left))
+		}
+		delete(normalized, "margin")
+	}
+	for _, key := range lengthParams {
+		value, ok := normalized[key]
+		if !ok {
+			continue
+		}
+		inches, err := ParseLength(value)
+		if err != nil {
+			errs = append(errs, &ParamError{Name: key, Reason: err.Error()})
+			continue
+		}
+		normalized[key] = formatInches(inches)
+	}
+	return normalized, errors.Join(errs...)
+}
+
+// validateParams checks that the parameters can be loaded with LoadSettings.
+func validateParams(params map[string]string) error {
+	normalized, err := normalizeParams(params)
+	if err != nil {
+		return err
+	}
+	var settings page.PrintToPDFParams
+	if err := queryParamsToStruct(normalized, &settings, "json"); err != nil {
+		return err
+	}
+	return validateSettings(normalized, settings)
+}
+
+// validateSettings checks the values of the settings loaded from the normalized params,
+// so that a request is rejected instead of being printed with settings Chrome would refuse or silently change.
+func validateSettings(params map[string]string, settings page.PrintToPDFParams) error {
+	var errs []error
+	invalid := func(name, reason string, args ...any) {
+		errs = append(errs, &ParamError{Name: name, Reason: fmt.Sprintf(reason, args...)})
+	}
+	for _, key := range []string{"sanitize", "lenient", "stream", "cache", "toc", "info", "lineNumbers"} {
+		if value, ok := params[key]; ok {
+			if _, err := strconv.ParseBool(value); err != nil {
+				invalid(key, "%q is not a boolean", value)
+			}
+		}
+	}
+	if value, ok := params["priority"]; ok && !slices.Contains(priorities, strings.ToLower(value)) {
+		invalid("priority", "%q is not one of %s", value, strings.Join(priorities, ", "))
+	}
+	if value, ok := params["queueTimeout"]; ok {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			invalid("queueTimeout", "%q is not a positive duration", value)
+		}
+	}
+	if value, ok := params["theme"]; ok {
+		if _, ok := markdownThemes[strings.ToLower(value)]; !ok {
+			invalid("theme", "%q is not one of %s", value, strings.Join(MarkdownThemes(), ", "))
+		}
+	}
+	if value, ok := params["tocLevels"]; ok {
+		if levels, err := strconv.Atoi(value); err != nil || levels < 1 || levels > 6 {
+			invalid("tocLevels", "%q is not a heading level between 1 and 6", value)
+		}
+	}
+	if value, ok := params["timezone"]; ok {
+		if _, err := time.LoadLocation(value); err != nil || value == "" {
+			invalid("timezone", "unknown time zone %q", value)
+		}
+	}
+	if value, ok := params["locale"]; ok && !validLocale(value) {
+		invalid("locale", "%q is not one of %s", value, strings.Join(Locales(), ", "))
+	}
+	for _, source := range templateSources {
+		if value, ok := params[source.file]; ok && !validTemplateName(value) {
+			invalid(source.file, "%q is not the name of a file of the template directory", value)
+		}
+	}
+	if _, ok := params["scale"]; ok && (settings.Scale < 0.1 || settings.Scale > 2) {
+		invalid("scale", "must be between 0.1 and 2, got %v", settings.Scale)
+	}
+	for key, value := range map[string]float64{"paperWidth": settings.PaperWidth, "paperHeight": settings.PaperHeight} {
+		if _, ok := params[key]; ok && value <= 0 {
+			invalid(key, "must be positive, got %v", value)
+		}
+	}
+	for key, value := range map[string]float64{
+		"marginTop":    settings.MarginTop,
+		"marginRight":  settings.MarginRight,
+		"marginBottom": settings.MarginBottom,
+		"marginLeft":   settings.MarginLeft,
+	} {
+		if value < 0 {
+			invalid(key, "must not be negative, got %v", value)
+		}
+	}
+
+	// Chrome defaults to a Letter page, and rotates it in landscape
+	width, height := settings.PaperWidth, settings.PaperHeight
+	if width <= 0 {
+		width = 8.5
+	}
+	if height <= 0 {
+		height = 11
+	}
+	if settings.Landscape {
+		width, height = height, width
+	}
+	if settings.MarginLeft+settings.MarginRight >= width {
+		invalid("marginLeft", "the left and right margins (%vin) do not leave room on a %vin wide page", settings.MarginLeft+settings.MarginRight, width)
+	}
+	if settings.MarginTop+settings.MarginBottom >= height {
+		invalid("marginTop", "the top and bottom margins (%vin) do not leave room on a %vin high page", settings.MarginTop+settings.MarginBottom, height)
+	}
+
+	if err := validatePageRanges(settings.PageRanges); err != nil {
+		invalid("pageRanges", "%v", err)
+	}
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].(*ParamError).Name < errs[j].(*ParamError).Name
+	})
+	return errors.Join(errs...)
+}
+
+// validatePageRanges checks the syntax of page ranges such as "1-5, 8, 11-13".
+// Like Chrome, it accepts open ranges such as "-5" or "11-".
+func validatePageRanges(ranges string) error {
+	if strings.TrimSpace(ranges) == "" {
+		return nil
+	}
+	for _, r := range strings.Split(ranges, ",") {
+		r = strings.TrimSpace(r)
+		start, end, isRange := strings.Cut(r, "-")
+		first, err := parsePageNumber(start, isRange)
+		if err != nil {
+			return fmt.Errorf("invalid range %q: %v", r, err)
+		}
+		if !isRange {
+			continue
+		}
+		last, err := parsePageNumber(end, true)
+		if err != nil {
+			return fmt.Errorf("invalid range %q: %v", r, err)
+		}
+		if strings.TrimSpace(start) == "" && strings.TrimSpace(end) == "" {
+			return fmt.Errorf("invalid range %q: expected a page number", r)
+		}
+		if first > 0 && last > 0 && first > last {
+			return fmt.Errorf("invalid range %q: the start is after the end", r)
+		}
+	}
+	return nil
+}
+
+// parsePageNumber parses a one based page number. When optional, an empty string is accepted and returns 0.
+func parsePageNumber(s string, optional bool) (int, error) {
+	s = strings.TrimSpace(s)
+	if s == "" && optional {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%q is not a page number", s)
+	}
+	return n, nil
+}
+
+func setDefault(params map[string]string, key, value string) {
+	if _, ok := params[key]; !ok {
+		params[key] = value
+	}
+}
+
+func formatInches(inches float64) string {
+	return strconv.FormatFloat(inches, 'f', -1, 64)
+}
+
+// cutPrefixFold is like strings.CutPrefix, but it ignores the case of the prefix.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	if len(s) < len(prefix) || !strings.EqualFold(s[:len(prefix)], prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
@@ -0,0 +1,184 @@
+﻿package lazypress
+
+import (
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestShouldNormalizeParamsIgnoringTheCase(t *testing.T) {
+	params, err := normalizeParams(map[string]string{"PRINTBACKGROUND": "true", "Output": "file", "paperwidth": "21cm"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"printBackground": "true", "output": "file", "paperWidth": formatInches(21 / 2.54)}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params to be %v, got %v", expected, params)
+	}
+}
+
+func TestShouldExpandPaperFormats(t *testing.T) {
+	params, err := normalizeParams(map[string]string{"format": "a4"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	width, _ := strconv.ParseFloat(params["paperWidth"], 64)
+	height, _ := strconv.ParseFloat(params["paperHeight"], 64)
+	if math.Abs(width-210/25.4) > 1e-9 || math.Abs(height-297/25.4) > 1e-9 {
+		t.Errorf("Expected A4 to be 210mm x 297mm, got %s x %s inches", params["paperWidth"], params["paperHeight"])
+	}
+	if _, ok := params["format"]; ok {
+		t.Error("Expected format to be removed once expanded")
+	}
+
+	params, err = normalizeParams(map[string]string{"format": "Letter", "paperHeight": "12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["paperWidth"] != "8.5" || params["paperHeight"] != "12" {
+		t.Errorf("Expected an explicit paperHeight to override the format, got %s x %s", params["paperWidth"], params["paperHeight"])
+	}
+}
+
+func TestShouldExpandMarginShorthand(t *testing.T) {
+	params, err := normalizeParams(map[string]string{"margin": "1in 2in", "marginLeft": "3in"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{"marginTop": "1", "marginRight": "2", "marginBottom": "1", "marginLeft": "3"}
+	if !reflect.DeepEqual(params, expected) {
+		t.Errorf("Expected params to be %v, got %v", expected, params)
+	}
+}
+
+func TestShouldReportEveryInvalidParam(t *testing.T) {
+	_, err := normalizeParams(map[string]string{"foo": "1", "bar": "2", "format": "B52", "marginTop": "1km"})
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+	for _, expected := range []string{"foo", "bar", "B52", "marginTop"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected the error to mention %s, got %v", expected, err)
+		}
+	}
+}
+
+func TestShouldLoadSettingsWithUnits(t *testing.T) {
+	var p PDF
+	if err := p.LoadSettings(map[string]string{"Format": "A4", "margin": "1cm", "Landscape": "true"}, &mockWriter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Settings.Landscape || math.Abs(p.Settings.MarginTop-1/2.54) > 1e-9 || math.Abs(p.Settings.PaperWidth-210/25.4) > 1e-9 {
+		t.Errorf("Expected the settings of an A4 landscape page with 1cm margins, got %+v", p.Settings)
+	}
+}
+
+func TestShouldRejectUnknownParams(t *testing.T) {
+	s := newServer(DefaultConfig())
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?papersize=A4", "<html></html>"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), "papersize") {
+		t.Errorf("Expected the error to mention the unknown parameter, got %q", w.Body.String())
+	}
+}
+
+func TestShouldValidateSettings(t *testing.T) {
+	invalid := map[string]map[string]string{
+		"scale":        {"scale": "3"},
+		"paperWidth":   {"paperWidth": "-1"},
+		"marginLeft":   {"margin": "0 5in"},
+		"marginTop":    {"marginTop": "6in", "marginBottom": "6in"},
+		"pageRanges":   {"pageRanges": "1-5, 8-3"},
+		"sanitize":     {"sanitize": "maybe"},
+		"landscape":    {"landscape": "sideways"},
+		"marginRight":  {"marginRight": "-1cm"},
+		"priority":     {"priority": "urgent"},
+		"queueTimeout": {"QUEUETIMEOUT": "soon"},
+	}
+	for name, params := range invalid {
+		err := validateParams(params)
+		errs := paramErrors(err)
+		if len(errs) == 0 || errs[0].Name != name {
+			t.Errorf("Expected %v to be reported as an invalid %s, got %v", params, name, err)
+		}
+	}
+
+	// NaN fails every comparison, so it would pass the range checks
+	for _, params := range []map[string]string{{"scale": "NaN"}, {"margin": "NaN"}, {"paperWidth": "Inf"}, {"paperHeight": "-infinity"}} {
+		var p PDF
+		if errs := paramErrors(p.LoadSettings(params, io.Discard, nil)); len(errs) == 0 {
+			t.Errorf("Expected %v to be invalid", params)
+		}
+	}
+
+	valid := []map[string]string{
+		{"scale": "0.5", "format": "A4", "margin": "1cm"},
+		{"pageRanges": "1-5, 8, 11-13"},
+		{"pageRanges": "-3, 5-"},
+		{"landscape": "true", "marginLeft": "5in", "marginRight": "5in"},
+		{"priority": "Batch", "queuetimeout": "10s"},
+	}
+	for _, params := range valid {
+		if err := validateParams(params); err != nil {
+			t.Errorf("Expected %v to be valid, got %v", params, err)
+		}
+	}
+}
+
+func TestShouldListInvalidParamsInProblemResponse(t *testing.T) {
+	s := newServer(DefaultConfig())
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?scale=5&pageRanges=a-b", "<html></html>"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Type") != ProblemContentType {
+		t.Errorf("Expected Content-Type to be %s, got %s", ProblemContentType, w.Header().Get("Content-Type"))
+	}
+	var problem Problem
+	if err := json.NewDecoder(w.Body).Decode(&problem); err != nil {
+		t.Fatal(err)
+	}
+	if problem.Status != http.StatusBadRequest || problem.RequestID == "" {
+		t.Errorf("Expected the problem to have the status and the request ID, got %+v", problem)
+	}
+	var names []string
+	for _, param := range problem.InvalidParams {
+		names = append(names, param.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"pageRanges", "scale"}) {
+		t.Errorf("Expected pageRanges and scale to be invalid, got %v", names)
+	}
+}
+
+func TestShouldIgnoreInvalidParamsInLenientMode(t *testing.T) {
+	s := newServer(DefaultConfig())
+	params, err := s.requestParams(httptest.NewRequest("POST", "/convert?lenient=true&papersize=A4&landscape=true", nil))
+	if err == nil {
+		t.Error("Expected the unknown parameter to be reported")
+	}
+	if params["landscape"] != "true" || params["lenient"] != "true" {
+		t.Errorf("Expected the valid parameters to be kept, got %v", params)
+	}
+	if _, ok := params["papersize"]; ok {
+		t.Errorf("Expected the unknown parameter to be left out, got %v", params)
+	}
+}
+
+func TestShouldRejectInvalidOutputsInLenientMode(t *testing.T) {
+	s := newServer(DefaultConfig())
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?lenient=true&output=carrier-pigeon&scale=9", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400 for an unknown output, got %d", w.Code)
+	}
+}
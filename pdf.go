@@ -5,16 +5,17 @@
 package lazypress
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
+	"math"
 	"os"
 	"reflect"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/chromedp/cdproto/page"
 	"github.com/microcosm-cc/bluemonday"
@@ -24,66 +25,179 @@ import (
 type PDF struct {
 	Content  []byte
 	Settings page.PrintToPDFParams
+	// Exporter is where the PDF is written. LoadSettings sets it to the writer it is given, while the Export of an output
+	// is only opened by OpenExport once there is a PDF to write.
 	Exporter io.Writer
 	Closer   io.Closer
-	filePath string
 	Sanitize bool
+	// RequestID identifies the conversion in the logs.
+	RequestID string
+	// Stream writes the PDF to the Exporter chunk by chunk while Chrome prints it, instead of keeping it in Content.
+	// It avoids holding large documents in memory. Export then only closes the output.
+	Stream bool
+	// Limits bound the time and resources used to render the PDF.
+	Limits RenderLimits
+	// Timings are the durations of the phases of the render, set as it goes.
+	Timings RenderTimings
+	// PageTemplates replace the header and footer templates of the first, odd or even pages.
+	PageTemplates PageTemplates
+	// TOC generates a table of contents from the headings of the document.
+	TOC TableOfContents
+	// Properties are entries of the document information dictionary of the PDF, set once it is printed,
+	// e.g. Author or Subject. Chrome sets the Title from the title of the document.
+	Properties map[string]string
+	// FontFaces are @font-face rules added to the document once it is loaded, e.g. the FontFaceCSS of a FontDir.
+	// The render waits for their fonts, so that they are embedded in the PDF.
+	FontFaces string
+	// TemplateData holds the values of the placeholders of the header and footer templates (see ExpandTemplates).
+	TemplateData TemplateData
+	// templatesExpanded is set once the placeholders of the templates are replaced.
+	templatesExpanded bool
+	// streamed is set once part of the PDF was written to the Exporter by a streaming render.
+	streamed bool
+	// exporters override the registered exporters, e.g. with the file exporter configured for a server.
+	exporters map[string]Exporter
+	// output is the Exporter chosen with the output setting, opened by OpenExport with outputMeta.
+	output     Exporter
+	outputName string
+	outputMeta ExportMetadata
 }
 
-// Export outputs the generated PDF to the configured output.
-// See LoadSettings to configure the output type.
-func (p PDF) Export() error {
-	if p.Exporter == nil {
-		return fmt.Errorf("no exporter set")
+// OpenExport opens the Export of the output chosen with LoadSettings, unless it is open already.
+// The render calls it once the PDF is printed, or when it starts to be streamed, so that a conversion that fails
+// before leaves nothing behind, e.g. an empty file.
+func (p *PDF) OpenExport() error {
+	if p.output == nil || p.Exporter != nil {
+		return nil
 	}
-	_, err := p.Exporter.Write(p.Content)
+	export, err := p.output.Open(p.outputMeta)
 	if err != nil {
-		return fmt.Errorf("could not export PDF: %v", err)
+		return fmt.Errorf("could not open %s output: %v", p.outputName, err)
 	}
-	log.Println("PDF exported")
-	if p.filePath != "" {
-		log.Println("PDF saved to", p.filePath)
+	p.Exporter = export
+	return nil
+}
+
+// AbortExport discards the open Export of a PDF that could not be exported, e.g. the file of a render that failed
+// while it was streamed. The Exports implementing Aborter are aborted, the others are closed.
+func (p *PDF) AbortExport() {
+	export, ok := p.Exporter.(Export)
+	if !ok {
+		return
 	}
-	if p.Closer != nil {
-		p.Closer.Close()
+	if aborter, ok := export.(Aborter); ok {
+		aborter.Abort()
+	} else {
+		export.Close()
 	}
-	return nil
+	p.Exporter = nil
 }
 
-func (p *PDF) createFile(filename string) (io.WriteCloser, error) {
-	dir, err := os.UserHomeDir()
-	if err != nil {
-		log.Println(err)
-		// falback to tmp dir
-		dir = os.TempDir()
+// Export outputs the generated PDF to the configured output.
+// See LoadSettings to configure the output type.
+func (p PDF) Export() error {
+	return p.ExportContext(context.Background())
+}
+
+// ExportContext is like Export, but it records the export as a span of the trace carried by ctx.
+func (p PDF) ExportContext(ctx context.Context) error {
+	_, err := p.ExportWithResult(ctx)
+	return err
+}
+
+// ExportWithResult is like ExportContext, but it also returns where the PDF went
+// when the Exporter is an Export (see LoadSettings). Otherwise, the result is nil.
+func (p PDF) ExportWithResult(ctx context.Context) (result *ExportResult, err error) {
+	_, span := startSpan(ctx, "lazypress.export")
+	defer func() {
+		endSpan(span, err)
+	}()
+	if err := p.OpenExport(); err != nil {
+		return nil, err
 	}
-	if filename == "" {
-		filename = "lazypress"
+	if p.Exporter == nil {
+		return nil, fmt.Errorf("no exporter set")
 	}
-	if !strings.HasSuffix(filename, "*.pdf") {
-		filename = filename + "*.pdf"
+	start := time.Now()
+	if p.streamed {
+		// the PDF was written while it was printed
+		p.logger().Info("PDF exported", "streamed", true)
+	} else {
+		if _, err := p.Exporter.Write(p.Content); err != nil {
+			p.AbortExport()
+			return nil, fmt.Errorf("could not export PDF: %v", err)
+		}
+		metrics.observePhase(phaseExport, start)
+		p.logger().Info("PDF exported", "bytes", len(p.Content))
 	}
-	file, err := ioutil.TempFile(dir, filename)
-	if err != nil {
-		log.Fatal(err)
+	if export, ok := p.Exporter.(Export); ok {
+		if err := export.Close(); err != nil {
+			p.AbortExport()
+			return nil, fmt.Errorf("could not export PDF: %v", err)
+		}
+		r := export.Result()
+		result = &r
+		if !result.Inline {
+			p.logger().Info("PDF saved", "output", result.Output, "location", result.Location)
+		}
+	}
+	if p.Closer != nil {
+		p.Closer.Close()
 	}
-	p.filePath = file.Name()
-	return file, nil
+	return result, nil
 }
 
 // LoadSettings loads a map[string]string of settings to configure the PDF.
 // The map can contain the following keys:
-// - output: the output type. Can be "file", "download".
-// - filename: the filename to use when outputting to a file.
-// - sanitize: whether to sanitize the HTML.
+//   - output: the name of the Exporter to use (see RegisterExporter). The built-in ones are "download", to write the PDF to w, and "file".
+//     Without output, the PDF is written to w, or to the standard output if w is nil.
+//   - filename: the filename to use when outputting to a file.
+//   - sanitize: whether to sanitize the HTML.
+//   - format: a named paper size (A3, A4, A5, Letter, Legal or Tabloid).
+//   - margin: the four margins, written like the CSS margin shorthand (e.g. "1cm 2cm").
+//   - stream: whether to stream the PDF to the output while it is printed (see PDF.Stream).
+//   - firstHeaderTemplate, firstFooterTemplate, oddHeaderTemplate, oddFooterTemplate, evenHeaderTemplate and evenFooterTemplate:
+//     the header and footer templates of the first, odd and even pages (see PageTemplates). An empty template prints nothing.
+//   - toc: whether to generate a table of contents (see TableOfContents), with tocTitle, tocLevels and tocTemplate.
+//   - var.NAME: a custom value for the {{var.NAME}} placeholders of the header and footer templates (see TemplateData).
+//   - timezone and locale: the time zone (e.g. Europe/Paris) and the locale (e.g. fr) of the dates of the templates.
+//
 // Since we are also using the same settings as the [github.com/chromedp/cdproto/page], you can also use the same keys.
 // See https://pkg.go.dev/github.com/chromedp/cdproto/page#PrintToPDFParams for more information.
+// The keys are case insensitive, and the paper sizes and margins accept units (see ParseLength).
+// Unknown keys and invalid values are reported as errors, each one as a *ParamError.
 func (p *PDF) LoadSettings(params map[string]string, w io.Writer, c io.Closer) error {
+	params, err := normalizeParams(params)
+	if err != nil {
+		return err
+	}
 	if err := queryParamsToStruct(params, &p.Settings, "json"); err != nil {
 		return err
 	}
+	if err := validateSettings(params, p.Settings); err != nil {
+		return err
+	}
+	for _, param := range pageTemplateParams {
+		if template, ok := params[param.name]; ok {
+			p.PageTemplates.setTemplate(param.name, template)
+		}
+	}
+	if toc, _ := strconv.ParseBool(params["toc"]); toc {
+		p.TOC.Enabled = true
+	}
+	p.TOC.Title = params["tocTitle"]
+	p.TOC.Template = params["tocTemplate"]
+	if levels, ok := params["tocLevels"]; ok {
+		p.TOC.Levels, _ = strconv.Atoi(levels)
+	}
 	if strings.ToLower(params["sanitize"]) == "true" {
 		p.Sanitize = true
+		p.PageTemplates.each(func(template *string) {
+			*template = string(SanitizeHTML([]byte(*template)))
+		})
+		if p.TOC.Template != "" {
+			p.TOC.Template = string(SanitizeHTML([]byte(p.TOC.Template)))
+		}
 		if p.Settings.HeaderTemplate != "" {
 			p.Settings.HeaderTemplate = string(SanitizeHTML([]byte(p.Settings.HeaderTemplate)))
 		}
@@ -91,29 +205,17 @@ func (p *PDF) LoadSettings(params map[string]string, w io.Writer, c io.Closer) e
 			p.Settings.FooterTemplate = string(SanitizeHTML([]byte(p.Settings.FooterTemplate)))
 		}
 	}
-	outputType := strings.ToLower(params["output"])
-	switch outputType {
-	case "file":
-		file, err := p.createFile(params["filename"])
-		if err != nil {
-			p.Exporter = w
-			p.Closer = c
-			return nil
-		}
-		p.Exporter = file
-		p.Closer = file
-	case "download":
-		p.Exporter = w
-		p.Closer = c
-	case "s3":
-		// TODO: implement
-		p.Exporter = w
-		p.Closer = c
-	case "email":
-		// TODO: implement
-		p.Exporter = w
-		p.Closer = c
-	default:
+	if stream, _ := strconv.ParseBool(params["stream"]); stream {
+		p.Stream = true
+	}
+	data, err := loadTemplateParams(params)
+	if err != nil {
+		return err
+	}
+	p.TemplateData.Vars, p.TemplateData.Location, p.TemplateData.Locale = data.Vars, data.Location, data.Locale
+	output := strings.ToLower(params["output"])
+	p.output = nil
+	if output == "" {
 		if w != nil {
 			p.Exporter = w
 			p.Closer = c
@@ -121,24 +223,53 @@ func (p *PDF) LoadSettings(params map[string]string, w io.Writer, c io.Closer) e
 			p.Exporter = os.Stdout
 			p.Closer = os.Stdout
 		}
+		return nil
+	}
+	exporter, ok := p.exporters[output]
+	if !ok {
+		exporter, ok = lookupExporter(output)
 	}
+	if !ok {
+		return &ParamError{Name: "output", Reason: fmt.Sprintf("%q is not one of %s", output, strings.Join(Exporters(), ", "))}
+	}
+	p.output, p.outputName = exporter, output
+	p.outputMeta = ExportMetadata{
+		Filename:    params["filename"],
+		ContentType: PDFContentType,
+		RequestID:   p.RequestID,
+		Writer:      w,
+	}
+	p.Exporter = nil
+	p.Closer = c
 	return nil
 }
 
 // SanitizeHTML sanitizes the HTML using [github.com/microcosm-cc/bluemonday].
 func SanitizeHTML(c []byte) []byte {
+	return ugcPolicy().SanitizeBytes(c)
+}
+
+// sanitizeHTMLWithPolicy sanitizes the HTML with the named policy (see SanitizeConfig).
+func sanitizeHTMLWithPolicy(c []byte, policy string) []byte {
+	if policy == "strict" {
+		return bluemonday.StrictPolicy().SanitizeBytes(c)
+	}
+	return SanitizeHTML(c)
+}
+
+func ugcPolicy() *bluemonday.Policy {
 	policy := bluemonday.UGCPolicy()
 	policy.AllowElements("html", "head", "title", "body", "style")
 	policy.AllowAttrs("style").OnElements("body", "table", "tr", "td", "p", "a", "font", "image")
 	policy.AllowAttrs("name").OnElements("meta")
 	policy.AllowAttrs("content").OnElements("meta")
-	return policy.SanitizeBytes(c)
+	return policy
 }
 
 func queryParamsToStruct(params map[string]string, structToUse any, tagStr string) error {
 	// From https://medium.com/wesionary-team/reflections-tutorial-query-string-to-struct-parser-in-go-b2f858f99ea1
 
-	var err error
+	var errs []error
 	dType := reflect.TypeOf(structToUse)
 	if dType.Elem().Kind() != reflect.Struct {
 		return errors.New("input must be a struct")
@@ -164,7 +295,8 @@ func queryParamsToStruct(params map[string]string, structToUse any, tagStr strin
 		case reflect.Int:
 			intVal, err := strconv.ParseInt(settingVal, 10, 64)
 			if err != nil {
-				return err
+				errs = append(errs, &ParamError{Name: key, Reason: fmt.Sprintf("%q is not an integer", settingVal)})
+				continue
 			}
 			if fieldVal.CanSet() {
 				fieldVal.SetInt(intVal)
@@ -172,15 +304,18 @@ func queryParamsToStruct(params map[string]string, structToUse any, tagStr strin
 		case reflect.Bool:
 			boolVal, err := strconv.ParseBool(settingVal)
 			if err != nil {
-				return err
+				errs = append(errs, &ParamError{Name: key, Reason: fmt.Sprintf("%q is not a boolean", settingVal)})
+				continue
 			}
 			if fieldVal.CanSet() {
 				fieldVal.SetBool(boolVal)
 			}
 		case reflect.Float64:
 			floatVal, err := strconv.ParseFloat(settingVal, 64)
-			if err != nil {
-				return err
+			// ParseFloat accepts NaN and Inf, which pass every range check
+			if err != nil || math.IsNaN(floatVal) || math.IsInf(floatVal, 0) {
+				errs = append(errs, &ParamError{Name: key, Reason: fmt.Sprintf("%q is not a number", settingVal)})
+				continue
 			}
 			if fieldVal.CanSet() {
 				fieldVal.SetFloat(floatVal)
@@ -190,11 +325,12 @@ func queryParamsToStruct(params map[string]string, structToUse any, tagStr strin
 				val := reflect.New(field.Type)
 				err := json.Unmarshal([]byte(settingVal), val.Interface())
 				if err != nil {
-					return err
+					errs = append(errs, &ParamError{Name: key, Reason: err.Error()})
+					continue
 				}
 				fieldVal.Set(val.Elem())
 			}
 		}
 	}
-	return err
+	return errors.Join(errs...)
 }
@@ -1,7 +1,11 @@
 ﻿package lazypress
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
@@ -47,60 +51,61 @@ func TestShouldWriteWithExporter(t *testing.T) {
 }
 
 func TestShouldCreatePDFWithLazypressInFilename(t *testing.T) {
-	var p PDF
-	f, err := p.createFile("")
+	e := &FileExporter{Dir: t.TempDir()}
+	export, err := e.Open(ExportMetadata{})
 	if err != nil {
-		t.Error("Expected no error when creating file")
-	}
-	filePath := f.(*os.File).Name()
-	defer os.Remove(filePath)
-	if !strings.Contains(filePath, "lazypress") {
-		t.Error("Expected filePath to contain lazypress")
+		t.Fatal("Expected no error when creating file")
 	}
-	if !strings.HasSuffix(filePath, ".pdf") {
-		t.Error("Expected filePath to have .pdf suffix")
+	defer export.Close()
+	id := export.Result().ID
+	if !strings.Contains(id, "lazypress") {
+		t.Error("Expected file ID to contain lazypress")
 	}
-	if p.filePath != filePath {
-		t.Error("Expected filePath to be the same as the created file")
+	if !strings.HasSuffix(id, ".pdf") {
+		t.Error("Expected file ID to have .pdf suffix")
 	}
 }
 
 func TestShouldCreatePDFNotWithLazypressInFilename(t *testing.T) {
-	var p PDF
-	fileName := "test"
-	f, err := p.createFile(fileName)
+	e := &FileExporter{Dir: t.TempDir()}
+	export, err := e.Open(ExportMetadata{Filename: "test"})
 	if err != nil {
-		t.Error("Expected no error when creating file")
+		t.Fatal("Expected no error when creating file")
 	}
-	filePath := f.(*os.File).Name()
-	defer os.Remove(filePath)
-	if !strings.Contains(filePath, "test") {
-		t.Error("Expected filePath to contain test")
+	defer export.Close()
+	id := export.Result().ID
+	if !strings.Contains(id, "test") || strings.Contains(id, "lazypress") {
+		t.Error("Expected file ID to contain test")
 	}
-	if !strings.HasSuffix(filePath, ".pdf") {
-		t.Error("Expected filePath to have .pdf suffix")
-	}
-	if p.filePath != filePath {
-		t.Error("Expected filePath to be the same as the created file")
+	if !strings.HasSuffix(id, ".pdf") {
+		t.Error("Expected file ID to have .pdf suffix")
 	}
 }
 
 func TestShouldLoadOutputToFileSetting(t *testing.T) {
-	var p PDF
+	dir := t.TempDir()
+	p := PDF{exporters: map[string]Exporter{"file": &FileExporter{Dir: dir}}}
 	params := map[string]string{
 		"output": "file",
 	}
-	p.LoadSettings(params, nil, nil)
-	if p.Exporter.(*os.File) == nil {
-		t.Error("Expected Exporter to be a file")
+	if err := p.LoadSettings(params, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Content = []byte("%PDF-1.4")
+	result, err := p.ExportWithResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Output != "file" || result.Inline || result.Location != "" {
+		t.Errorf("Expected the PDF to be exported to a file, without disclosing its path, got %+v", result)
 	}
-	if p.Closer.(*os.File) == nil {
-		t.Error("Expected Closer to be a file")
+	content, err := os.ReadFile(filepath.Join(dir, result.ID))
+	if err != nil {
+		t.Fatal(err)
 	}
-	if p.filePath == "" {
-		t.Error("Expected filePath to be set")
+	if string(content) != "%PDF-1.4" {
+		t.Errorf("Expected the file to contain the PDF, got %q", content)
 	}
-	defer os.Remove(p.filePath)
 }
 
 func TestShouldLoadOutputToDownloadSetting(t *testing.T) {
@@ -109,15 +114,19 @@ func TestShouldLoadOutputToDownloadSetting(t *testing.T) {
 	params := map[string]string{
 		"output": "download",
 	}
-	p.LoadSettings(params, w, nil)
-	if p.Exporter.(*mockWriter) == nil {
-		t.Error("Expected Exporter to be a mockWriter")
+	if err := p.LoadSettings(params, w, nil); err != nil {
+		t.Fatal(err)
 	}
-	if p.Exporter != w {
-		t.Error("Expected Exporter to be the same as the mockWriter")
+	p.Content = []byte("%PDF-1.4")
+	result, err := p.ExportWithResult(context.Background())
+	if err != nil {
+		t.Fatal(err)
 	}
-	if p.filePath != "" {
-		t.Error("Expected filePath to be empty")
+	if string(w.written) != "%PDF-1.4" {
+		t.Error("Expected the PDF to be written to the mockWriter")
+	}
+	if result.Output != "download" || !result.Inline || result.Location != "" || result.Bytes != 8 {
+		t.Errorf("Expected the PDF to be downloaded, got %+v", result)
 	}
 }
 
@@ -132,22 +141,16 @@ func TestShouldLoadPassedWriterAsDefaultExporter(t *testing.T) {
 	if p.Closer.(*mockCloser) != c {
 		t.Error("Expected Closer to be the same as the mockCloser")
 	}
-	if p.filePath != "" {
-		t.Error("Expected filePath to be empty")
-	}
 }
 
 func TestShouldSetStdoutAsWriterWhenNoWriterPassedAndNoOutputParamPassed(t *testing.T) {
 	var p PDF
 	p.LoadSettings(map[string]string{}, nil, nil)
-	if p.Exporter.(*os.File) == nil {
-		t.Error("Expected Exporter to be a file")
+	if p.Exporter.(*os.File) != os.Stdout {
+		t.Error("Expected Exporter to be the standard output")
 	}
-	if p.Closer.(*os.File) == nil {
-		t.Error("Expected Closer to be a file")
-	}
-	if p.filePath != "" {
-		t.Error("Expected filePath to be empty")
+	if p.Closer.(*os.File) != os.Stdout {
+		t.Error("Expected Closer to be the standard output")
 	}
 }
 
@@ -214,3 +217,52 @@ func TestShouldLoadPassedSettings(t *testing.T) {
 	}
 
 }
+
+func TestShouldLoadStreamSetting(t *testing.T) {
+	var p PDF
+	if err := p.LoadSettings(map[string]string{"stream": "true"}, &mockWriter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Stream || !p.streaming() {
+		t.Error("Expected the PDF to be streamed")
+	}
+}
+
+func TestShouldNotWriteStreamedPDFAgainOnExport(t *testing.T) {
+	m := &mockWriter{}
+	c := &mockCloser{}
+	p := PDF{Exporter: m, Closer: c, Stream: true, streamed: true}
+	if err := p.Export(); err != nil {
+		t.Errorf("Expected no error when exporting, got %v", err)
+	}
+	if m.written != nil {
+		t.Errorf("Expected nothing to be written, got %q", m.written)
+	}
+	if c.count != 1 {
+		t.Errorf("Expected Closer to be closed once, got %d", c.count)
+	}
+}
+
+func TestShouldStreamTheRenderToTheExporter(t *testing.T) {
+	if _, err := (&Server{}).resolveChromePath(); err != nil {
+		t.Skip("chrome is not available:", err)
+	}
+	html := []byte(`<html><body>Hello World</body></html>`)
+	var buf bytes.Buffer
+	p := PDF{Exporter: &buf, Stream: true}
+	if err := p.Render(context.Background(), html); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(buf.Bytes(), []byte("%PDF")) {
+		t.Errorf("Expected a PDF to be streamed to the exporter, got %d bytes", buf.Len())
+	}
+	if p.Content != nil {
+		t.Error("Expected a streamed PDF not to be kept in Content")
+	}
+
+	buf.Reset()
+	p = PDF{Exporter: &buf, Stream: true, Limits: RenderLimits{MaxPDFBytes: 10}}
+	if err := p.Render(context.Background(), html); !errors.Is(err, ErrPDFTooLarge) {
+		t.Errorf("Expected ErrPDFTooLarge when the streamed PDF is too large, got %v", err)
+	}
+}
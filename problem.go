@@ -0,0 +1,50 @@
+﻿package lazypress
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// ProblemContentType is the content type of the error responses of the server.
+const ProblemContentType = "application/problem+json"
+
+// Problem describes an error response of the server, as defined by RFC 7807.
+type Problem struct {
+	Type      string `json:"type"`
+	Title     string `json:"title"`
+	Status    int    `json:"status"`
+	Detail    string `json:"detail,omitempty"`
+	Instance  string `json:"instance,omitempty"`
+	RequestID string `json:"requestId,omitempty"`
+	// InvalidParams lists the parameters of the request that could not be used.
+	InvalidParams []InvalidParam `json:"invalidParams,omitempty"`
+	// Limit is the name of the limit exceeded by the conversion, e.g. loadTimeout (see LimitsConfig).
+	Limit string `json:"limit,omitempty"`
+}
+
+// InvalidParam is a parameter rejected by the server, with the reason why.
+type InvalidParam struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// writeProblem writes an application/problem+json response.
+// The ParamErrors found in err, if any, are listed as invalid parameters, and the exceeded limit is named.
+func writeProblem(w http.ResponseWriter, r *http.Request, status int, detail string, err error) {
+	problem := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		RequestID: w.Header().Get(RequestIDHeader),
+	}
+	problem.Limit = exceededLimit(err)
+	for _, paramErr := range paramErrors(err) {
+		problem.InvalidParams = append(problem.InvalidParams, InvalidParam{Name: paramErr.Name, Reason: paramErr.Reason})
+	}
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(problem)
+}
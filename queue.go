@@ -0,0 +1,130 @@
+﻿package lazypress
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"strings"
+	"sync"
+)
+
+// Priority classes of the conversions waiting for a render, from the most to the least urgent.
+// They are chosen with the priority parameter.
+const (
+	priorityInteractive = "interactive"
+	priorityBatch       = "batch"
+)
+
+var priorities = []string{priorityInteractive, priorityBatch}
+
+var (
+	errQueueFull    = errors.New("the render queue is full")
+	errQueueTimeout = errors.New("timed out waiting for a render")
+)
+
+// renderQueue limits the number of renders in progress.
+// The conversions over the limit wait in a bounded queue, and the most urgent priority class is served first,
+// in the order of arrival within a class.
+type renderQueue struct {
+	maxConcurrent int
+	maxWaiting    int
+
+	mu      sync.Mutex
+	running int
+	waiting map[string]*list.List
+}
+
+// queueWaiter is a conversion waiting for a render. ready is closed when the render is handed to it.
+type queueWaiter struct {
+	ready chan struct{}
+}
+
+func newRenderQueue(cfg QueueConfig) *renderQueue {
+	q := &renderQueue{
+		maxConcurrent: cfg.MaxConcurrent,
+		maxWaiting:    cfg.MaxWaiting,
+		waiting:       make(map[string]*list.List, len(priorities)),
+	}
+	for _, priority := range priorities {
+		q.waiting[priority] = list.New()
+	}
+	return q
+}
+
+// acquire waits for a render until ctx is done.
+// It returns errQueueFull straight away when too many conversions are waiting,
+// and errQueueTimeout when ctx is done first. Otherwise, the returned function must be called once the render is over.
+func (q *renderQueue) acquire(ctx context.Context, priority string) (func(), error) {
+	if q.maxConcurrent <= 0 {
+		return func() {}, nil
+	}
+	priority = strings.ToLower(priority)
+	waiters, ok := q.waiting[priority]
+	if !ok {
+		priority = priorityInteractive
+		waiters = q.waiting[priority]
+	}
+
+	q.mu.Lock()
+	if q.running < q.maxConcurrent && q.waitingLocked() == 0 {
+		q.running++
+		q.mu.Unlock()
+		return q.release, nil
+	}
+	if q.waitingLocked() >= q.maxWaiting {
+		q.mu.Unlock()
+		return nil, errQueueFull
+	}
+	waiter := &queueWaiter{ready: make(chan struct{})}
+	elem := waiters.PushBack(waiter)
+	q.mu.Unlock()
+
+	metrics.queueWaiting.WithLabelValues(priority).Inc()
+	defer metrics.queueWaiting.WithLabelValues(priority).Dec()
+
+	select {
+	case <-waiter.ready:
+		return q.release, nil
+	case <-ctx.Done():
+		q.mu.Lock()
+		select {
+		case <-waiter.ready:
+			// the render was handed over in the meantime, pass it on
+			q.mu.Unlock()
+			q.release()
+		default:
+			waiters.Remove(elem)
+			q.mu.Unlock()
+		}
+		return nil, errQueueTimeout
+	}
+}
+
+// release ends a render, and hands it over to the next conversion waiting, if any.
+func (q *renderQueue) release() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, priority := range priorities {
+		if front := q.waiting[priority].Front(); front != nil {
+			q.waiting[priority].Remove(front)
+			close(front.Value.(*queueWaiter).ready)
+			return
+		}
+	}
+	q.running--
+}
+
+func (q *renderQueue) waitingLocked() int {
+	n := 0
+	for _, waiters := range q.waiting {
+		n += waiters.Len()
+	}
+	return n
+}
+
+// stats returns the number of renders in progress and of conversions waiting.
+func (q *renderQueue) stats() (running, waiting int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.running, q.waitingLocked()
+}
@@ -0,0 +1,130 @@
+﻿package lazypress
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestShouldLimitConcurrentRenders(t *testing.T) {
+	q := newRenderQueue(QueueConfig{MaxConcurrent: 2, MaxWaiting: 1})
+	first, err := q.acquire(context.Background(), priorityInteractive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.acquire(context.Background(), priorityInteractive); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan error)
+	go func() {
+		_, err := q.acquire(context.Background(), priorityInteractive)
+		acquired <- err
+	}()
+	waitForWaiting(t, q, 1)
+	if _, err := q.acquire(context.Background(), priorityInteractive); !errors.Is(err, errQueueFull) {
+		t.Errorf("Expected the queue to be full, got %v", err)
+	}
+
+	first()
+	if err := <-acquired; err != nil {
+		t.Errorf("Expected the waiting conversion to get the render, got %v", err)
+	}
+	if running, waiting := q.stats(); running != 2 || waiting != 0 {
+		t.Errorf("Expected 2 renders and nothing waiting, got %d and %d", running, waiting)
+	}
+}
+
+func TestShouldServeInteractiveConversionsFirst(t *testing.T) {
+	q := newRenderQueue(QueueConfig{MaxConcurrent: 1, MaxWaiting: 10})
+	release, _ := q.acquire(context.Background(), priorityInteractive)
+
+	order := make(chan string, 2)
+	for i, priority := range []string{priorityBatch, priorityInteractive} {
+		go func() {
+			done, err := q.acquire(context.Background(), priority)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			order <- priority
+			done()
+		}()
+		waitForWaiting(t, q, i+1)
+	}
+
+	release()
+	if first, second := <-order, <-order; first != priorityInteractive || second != priorityBatch {
+		t.Errorf("Expected the interactive conversion to go first, got %s then %s", first, second)
+	}
+	if running, _ := q.stats(); running != 0 {
+		t.Errorf("Expected no render in progress, got %d", running)
+	}
+}
+
+func TestShouldTimeOutWaitingForARender(t *testing.T) {
+	q := newRenderQueue(QueueConfig{MaxConcurrent: 1, MaxWaiting: 1})
+	release, _ := q.acquire(context.Background(), priorityInteractive)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := q.acquire(ctx, priorityBatch); !errors.Is(err, errQueueTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if _, waiting := q.stats(); waiting != 0 {
+		t.Errorf("Expected the conversion to leave the queue, got %d waiting", waiting)
+	}
+	release()
+	if running, _ := q.stats(); running != 0 {
+		t.Errorf("Expected no render in progress, got %d", running)
+	}
+}
+
+func TestShouldRejectConversionsWhenTheQueueIsFull(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Queue = QueueConfig{MaxConcurrent: 1, RetryAfter: 1500 * time.Millisecond}
+	s := newServer(cfg)
+	release, _ := s.queue.acquire(context.Background(), priorityInteractive)
+	defer release()
+
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newConvertRequest(t, "/convert?priority=batch", "<html><body>Hello World</body></html>"))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code to be 503, got %d", w.Code)
+	}
+	if w.Header().Get("Retry-After") != "2" {
+		t.Errorf("Expected Retry-After to be 2, got %q", w.Header().Get("Retry-After"))
+	}
+	if w.Header().Get("Content-Type") != ProblemContentType {
+		t.Errorf("Expected a problem, got %s", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestShouldTimeOutConversionsWithTheirQueueTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Queue = QueueConfig{MaxConcurrent: 1, MaxWaiting: 1, Timeout: time.Minute}
+	s := newServer(cfg)
+	release, _ := s.queue.acquire(context.Background(), priorityInteractive)
+	defer release()
+
+	start := time.Now()
+	_, err := s.waitForRender(context.Background(), map[string]string{"queueTimeout": "10ms"})
+	if !errors.Is(err, errQueueTimeout) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Error("Expected the queue timeout of the request to be used")
+	}
+}
+
+func waitForWaiting(t *testing.T, q *renderQueue, n int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		if _, waiting := q.stats(); waiting == n {
+			return
+		}
+	}
+	t.Fatalf("Expected %d conversions to wait", n)
+}
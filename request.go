@@ -0,0 +1,232 @@
+﻿package lazypress
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+)
+
+// convertRequest is the content of a request to /convert.
+type convertRequest struct {
+	html []byte
+	// format is the format of the document, rendered to HTML by renderDocument unless it is HTML already
+	format documentFormat
+	// contentType is the content type of the document, with its parameters, e.g. the boundary of an MHTML archive
+	contentType string
+	// templates are the header, footer and TOC templates sent as parts of a multipart request, by part name
+	templates map[string][]byte
+}
+
+// documentFormat is the format of the document of a request to /convert. It is also the name of the part holding it
+// in a multipart request.
+type documentFormat string
+
+const (
+	formatHTML     documentFormat = "html"
+	formatMarkdown documentFormat = "markdown"
+	formatText     documentFormat = "text"
+	formatEmail    documentFormat = "email"
+	formatMHTML    documentFormat = "mhtml"
+)
+
+// contentTypeFormats are the formats of the documents by content type.
+var contentTypeFormats = map[string]documentFormat{
+	"text/html":          formatHTML,
+	MarkdownContentType:  formatMarkdown,
+	PlainTextContentType: formatText,
+	EmailContentType:     formatEmail,
+	MHTMLContentType:     formatMHTML,
+}
+
+// documentFormats are the formats of the documents, in the order they are listed in the error messages.
+var documentFormats = []documentFormat{formatHTML, formatMarkdown, formatText, formatEmail, formatMHTML}
+
+// templateSources are where the header, footer and TOC templates come from: the part of a multipart request,
+// or else the file of the template directory chosen with a parameter, for the template parameter they replace.
+var templateSources = []struct {
+	part, file, param string
+}{
+	{"header", "headerFile", "headerTemplate"},
+	{"footer", "footerFile", "footerTemplate"},
+	{"firstHeader", "firstHeaderFile", "firstHeaderTemplate"},
+	{"firstFooter", "firstFooterFile", "firstFooterTemplate"},
+	{"oddHeader", "oddHeaderFile", "oddHeaderTemplate"},
+	{"oddFooter", "oddFooterFile", "oddFooterTemplate"},
+	{"evenHeader", "evenHeaderFile", "evenHeaderTemplate"},
+	{"evenFooter", "evenFooterFile", "evenFooterTemplate"},
+	{"toc", "tocFile", "tocTemplate"},
+}
+
+// isTemplatePart reports whether the part of a multipart request holds a template.
+func isTemplatePart(name string) bool {
+	return slices.ContainsFunc(templateSources, func(source struct{ part, file, param string }) bool {
+		return source.part == name
+	})
+}
+
+// readConvertRequest reads the body of a request to /convert. It is either the document itself, in one of the formats of
+// contentTypeFormats, or a multipart/form-data form with the document in the part named after its format, e.g. html,
+// and the templates in the parts of templateSources.
+// Unknown parts are reported as a *ParamError.
+func readConvertRequest(r *http.Request) (convertRequest, error) {
+	var req convertRequest
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		body, err := readRequest(r.Body)
+		req.html = body
+		req.format, req.contentType = contentTypeFormats[mediaType], r.Header.Get("Content-Type")
+		return req, err
+	}
+	defer r.Body.Close()
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return req, &ParamError{Name: "body", Reason: err.Error()}
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			return req, nil
+		}
+		if err != nil {
+			return req, wrapMultipartError(err)
+		}
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return req, wrapMultipartError(err)
+		}
+		switch name := part.FormName(); {
+		case slices.Contains(documentFormats, documentFormat(name)):
+			req.html = content
+			req.format, req.contentType = documentFormat(name), part.Header.Get("Content-Type")
+		case isTemplatePart(name):
+			if req.templates == nil {
+				req.templates = map[string][]byte{}
+			}
+			req.templates[name] = content
+		default:
+			return req, &ParamError{Name: name, Reason: "unknown part, expected html, markdown, text, email, mhtml or a template like header or footer"}
+		}
+	}
+}
+
+// renderDocument renders the document of the request to HTML, unless it is HTML already, and returns the properties
+// of the PDF it sets, e.g. the author of a Markdown document. An invalid document is reported as a *ParamError.
+// With safe, the raw HTML of a Markdown document is left out.
+func renderDocument(req convertRequest, params map[string]string, safe bool) ([]byte, map[string]string, error) {
+	switch req.format {
+	case formatMarkdown:
+		doc, err := RenderMarkdown(req.html, MarkdownOptions{Theme: params["theme"], Safe: safe})
+		if err != nil || doc.Author == "" {
+			return doc.HTML, nil, err
+		}
+		return doc.HTML, map[string]string{"Author": doc.Author}, nil
+	case formatText:
+		lineNumbers, _ := strconv.ParseBool(params["lineNumbers"])
+		return RenderPlainText(req.html, TextOptions{LineNumbers: lineNumbers}), nil, nil
+	case formatEmail:
+		html, err := RenderEmail(req.html)
+		return html, nil, err
+	case formatMHTML:
+		html, err := RenderMHTML(req.html, req.contentType)
+		return html, nil, err
+	}
+	return req.html, nil, nil
+}
+
+// wrapMultipartError reports a malformed form as an invalid body, but keeps the errors of the reader as they are,
+// e.g. when the body goes over the size limit.
+func wrapMultipartError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return err
+	}
+	return &ParamError{Name: "body", Reason: err.Error()}
+}
+
+// applyHeaderFooter sets the header and footer templates of the PDF, including its PageTemplates, and the template of its TOC,
+// from the parts of the request or from the files of the template directory (see templateSources).
+// They take precedence over the template parameters.
+func (s *Server) applyHeaderFooter(p *PDF, params map[string]string, req convertRequest) error {
+	var errs []error
+	templates := map[string][]byte{}
+	for _, source := range templateSources {
+		content, ok := req.templates[source.part]
+		if !ok && params[source.file] != "" {
+			var err error
+			content, err = s.readTemplate(source.file, params[source.file])
+			errs = append(errs, err)
+		}
+		if content != nil {
+			templates[source.param] = content
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+
+	sanitize := func(template []byte) string {
+		if p.Sanitize {
+			return string(SanitizeHTML(template))
+		}
+		return string(template)
+	}
+	header, hasHeader := templates["headerTemplate"]
+	footer, hasFooter := templates["footerTemplate"]
+	for param, template := range templates {
+		switch param {
+		case "headerTemplate", "footerTemplate":
+		case "tocTemplate":
+			p.TOC.Template = sanitize(template)
+		default:
+			p.PageTemplates.setTemplate(param, sanitize(template))
+		}
+	}
+	if !hasHeader && !hasFooter {
+		return nil
+	}
+	if hasHeader {
+		p.Settings.HeaderTemplate = sanitize(header)
+	}
+	if hasFooter {
+		p.Settings.FooterTemplate = sanitize(footer)
+	}
+	if _, ok := params["displayHeaderFooter"]; !ok {
+		p.Settings.DisplayHeaderFooter = true
+	}
+	// Chrome prints its default header and footer when a template is empty
+	if p.Settings.HeaderTemplate == "" {
+		p.Settings.HeaderTemplate = blankTemplate
+	}
+	if p.Settings.FooterTemplate == "" {
+		p.Settings.FooterTemplate = blankTemplate
+	}
+	return nil
+}
+
+// readTemplate reads a file of the template directory. It cannot read files outside of it.
+func (s *Server) readTemplate(param, name string) ([]byte, error) {
+	if s.config.Templates.Dir == "" {
+		return nil, &ParamError{Name: param, Reason: "no template directory is configured"}
+	}
+	if !validTemplateName(name) {
+		return nil, &ParamError{Name: param, Reason: fmt.Sprintf("%q is not the name of a file of the template directory", name)}
+	}
+	root, err := os.OpenRoot(s.config.Templates.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not open template directory: %v", err)
+	}
+	defer root.Close()
+	content, err := root.ReadFile(name)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, &ParamError{Name: param, Reason: fmt.Sprintf("there is no template %q", name)}
+	}
+	if err != nil {
+		return nil, &ParamError{Name: param, Reason: fmt.Sprintf("could not read template %q", name)}
+	}
+	return content, nil
+}
@@ -2,29 +2,382 @@
 
 import (
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
-	"log"
+	"math"
+	"mime"
 	"net/http"
 	"net/url"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"github.com/chromedp/cdproto/page"
 	"github.com/chromedp/chromedp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // InitServer initializes the server.
 // It takes the port to listen on and the path to the chrome executable.
 // If the chrome executable is not provided, the server will use the default options of [github.com/chromedp/chromedp].
 // The default port is 3444.
+// InitServer blocks until the process receives SIGINT or SIGTERM, then shuts the server down gracefully.
 func InitServer(port int, chromePath string) {
-	log.Println("Starting server on port", port)
+	if err := NewServer(port, chromePath).Run(); err != nil {
+		getLogger().Error("server stopped", "error", err)
+		os.Exit(1)
+	}
+}
+
+// Run starts the server and blocks until the process receives SIGINT or SIGTERM,
+// then shuts the server down gracefully, waiting up to ShutdownTimeout for the active renders.
+func (s *Server) Run() error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	getLogger().Info("shutting down server")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		getLogger().Error("could not shut down server gracefully", "error", err)
+	}
+	getLogger().Info("server stopped")
+	return nil
+}
+
+// Server is the lazypress HTTP server.
+// Create it with NewServer or NewServerFromConfig, run it with Start and stop it with Shutdown.
+type Server struct {
+	Port       int
+	ChromePath string
+	// ReadTimeout is the maximum duration for reading the entire request.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration before timing out writes of the response.
+	// It must be long enough to cover the rendering of a PDF.
+	WriteTimeout time.Duration
+	// ShutdownTimeout is how long InitServer waits for active renders when shutting down.
+	ShutdownTimeout time.Duration
+	// ReadinessTimeout is how long /readyz waits for Chrome to print a test page.
+	ReadinessTimeout time.Duration
+	// ReadinessInterval is how long /readyz reuses the result of its last test page, so that probes do not start Chrome every time.
+	ReadinessInterval time.Duration
+
+	config Config
+	// files saves the PDFs of the file output, as configured
+	files *FileExporter
+	// exporters override the registered ones for the conversions of the server
+	exporters map[string]Exporter
+	// cache is nil when the render cache is disabled
+	cache RenderCache
+	// queue limits the renders in progress
+	queue *renderQueue
+	// fonts is nil when no font directory is configured
+	fonts *FontDir
+
+	mu         sync.Mutex
+	httpServer *http.Server
+	baseCtx    context.Context
+	cancel     context.CancelFunc
+	renders    sync.WaitGroup
+	draining   int32
+
+	activeMu   sync.Mutex
+	active     map[uint64]activeRender
+	nextRender uint64
+
+	// readyMu guards the last result of the render check of /readyz, checked at readyAt
+	readyMu  sync.Mutex
+	readyAt  time.Time
+	readyErr error
+}
+
+type activeRender struct {
+	Started time.Time
+	Size    int
+}
+
+// NewServer creates a Server listening on the given port and using the given chrome executable.
+// The rest of the configuration is DefaultConfig.
+func NewServer(port int, chromePath string) *Server {
+	cfg := DefaultConfig()
+	cfg.Port = port
+	cfg.Chrome.Path = chromePath
+	return newServer(cfg)
+}
+
+// NewServerFromConfig creates a Server from the given configuration, after validating it.
+// See LoadConfig to read the configuration from a file and the environment.
+func NewServerFromConfig(cfg Config) (*Server, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return newServer(cfg), nil
+}
+
+func newServer(cfg Config) *Server {
+	ctx, cancel := context.WithCancel(context.Background())
+	files := &FileExporter{
+		Dir:       cfg.Output.Dir,
+		Naming:    cfg.Output.Naming,
+		Overwrite: cfg.Output.Overwrite,
+		Retention: cfg.Output.Retention,
+	}
+	var fonts *FontDir
+	if cfg.Fonts.Dir != "" {
+		fonts = &FontDir{Dir: cfg.Fonts.Dir}
+	}
+	return &Server{
+		Port:              cfg.Port,
+		ChromePath:        cfg.Chrome.Path,
+		ReadTimeout:       cfg.Limits.ReadTimeout,
+		WriteTimeout:      cfg.Limits.WriteTimeout,
+		ShutdownTimeout:   cfg.Limits.ShutdownTimeout,
+		ReadinessTimeout:  10 * time.Second,
+		ReadinessInterval: 30 * time.Second,
+		config:            cfg,
+		files:             files,
+		exporters:         map[string]Exporter{"file": files},
+		cache:             newRenderCache(cfg.Cache),
+		queue:             newRenderQueue(cfg.Queue),
+		fonts:             fonts,
+		baseCtx:           ctx,
+		cancel:            cancel,
+	}
+}
+
+// Handler returns the http.Handler serving the lazypress endpoints.
+func (s *Server) Handler() http.Handler {
 	mux := http.NewServeMux()
-	mux.HandleFunc("/convert", convertHTMLServerHandler(chromePath))
-	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
-		log.Fatal(err)
+	mux.HandleFunc("/convert", s.requireAuth(s.handleConvert))
+	mux.HandleFunc("/profiles", s.requireAuth(s.handleProfiles))
+	mux.HandleFunc("GET /files", s.requireAuth(s.handleListFiles))
+	mux.HandleFunc("GET /files/{id...}", s.handleGetFile)
+	mux.HandleFunc("DELETE /files/{id...}", s.requireAuth(s.handleDeleteFile))
+	mux.HandleFunc("GET /fonts", s.requireAuth(s.handleListFonts))
+	mux.HandleFunc("PUT /fonts/{file...}", s.requireAuth(s.handleUploadFont))
+	mux.HandleFunc("DELETE /fonts/{file...}", s.requireAuth(s.handleDeleteFont))
+	mux.HandleFunc("/healthz", s.handleHealthz)
+	mux.HandleFunc("/readyz", s.handleReadyz)
+	mux.HandleFunc("/debug/chrome", s.requireAuth(s.handleDebugChrome))
+	mux.Handle("/metrics", MetricsHandler())
+	return mux
+}
+
+// Start starts listening for requests.
+// It blocks until the server is stopped and returns nil if it was stopped by Shutdown.
+func (s *Server) Start() error {
+	s.mu.Lock()
+	if s.isDraining() {
+		s.mu.Unlock()
+		return http.ErrServerClosed
+	}
+	s.httpServer = &http.Server{
+		Addr:         fmt.Sprintf(":%d", s.Port),
+		Handler:      s.Handler(),
+		ReadTimeout:  s.ReadTimeout,
+		WriteTimeout: s.WriteTimeout,
+	}
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	getLogger().Info("starting server", "port", s.Port)
+	go s.files.runCleanup(s.baseCtx)
+	if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
 	}
+	return nil
+}
+
+// Shutdown gracefully stops the server.
+// New conversions are refused straight away, while the ones in progress
+// are given until the context is done to complete.
+// After that, any remaining render is cancelled, which closes its Chrome process
+// and removes its temporary files.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	atomic.StoreInt32(&s.draining, 1)
+	httpServer := s.httpServer
+	s.mu.Unlock()
+
+	var err error
+	if httpServer != nil {
+		err = httpServer.Shutdown(ctx)
+	}
+	s.cancel()
+	s.renders.Wait()
+	return err
+}
+
+var errServerDraining = errors.New("the server is shutting down")
+
+func (s *Server) isDraining() bool {
+	return atomic.LoadInt32(&s.draining) == 1
+}
+
+// trackRender registers a render as in progress, unless the server is shutting down.
+// The returned function must be called once the render is over.
+func (s *Server) trackRender(size int) (func(), error) {
+	// checked under the lock Shutdown takes to start draining, so that no render is added once it waits for them
+	s.mu.Lock()
+	if s.isDraining() {
+		s.mu.Unlock()
+		return nil, errServerDraining
+	}
+	s.renders.Add(1)
+	s.mu.Unlock()
+	metrics.rendersActive.Inc()
+	s.activeMu.Lock()
+	if s.active == nil {
+		s.active = make(map[uint64]activeRender)
+	}
+	s.nextRender++
+	id := s.nextRender
+	s.active[id] = activeRender{Started: time.Now(), Size: size}
+	s.activeMu.Unlock()
+	return func() {
+		s.activeMu.Lock()
+		delete(s.active, id)
+		s.activeMu.Unlock()
+		metrics.rendersActive.Dec()
+		s.renders.Done()
+	}, nil
+}
+
+func (s *Server) activeRenders() []activeRender {
+	s.activeMu.Lock()
+	defer s.activeMu.Unlock()
+	renders := make([]activeRender, 0, len(s.active))
+	for _, r := range s.active {
+		renders = append(renders, r)
+	}
+	return renders
+}
+
+// newAllocator returns the context to create Chrome instances from.
+// It is bound to the server's lifetime, so that Shutdown can close the browsers still running.
+func (s *Server) newAllocator() (context.Context, context.CancelFunc) {
+	if s.ChromePath == "" && len(s.config.Chrome.Flags) == 0 {
+		return context.WithCancel(s.baseCtx)
+	}
+	opt := append([]chromedp.ExecAllocatorOption{}, chromedp.DefaultExecAllocatorOptions[:]...)
+	if s.ChromePath != "" {
+		opt = append(opt, chromedp.ExecPath(s.ChromePath))
+	}
+	for _, flag := range s.config.Chrome.Flags {
+		name, value, hasValue := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+		if hasValue {
+			opt = append(opt, chromedp.Flag(name, value))
+		} else {
+			opt = append(opt, chromedp.Flag(name, true))
+		}
+	}
+	return chromedp.NewExecAllocator(s.baseCtx, opt...)
+}
+
+// requireAuth rejects the requests without one of the configured bearer tokens.
+// When no token is configured, every request is accepted.
+func (s *Server) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(s.config.Auth.Tokens) == 0 {
+			next(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if ok {
+			for _, allowed := range s.config.Auth.Tokens {
+				if subtle.ConstantTimeCompare([]byte(token), []byte(allowed)) == 1 {
+					next(w, r)
+					return
+				}
+			}
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer realm="lazypress"`)
+		writeProblem(w, r, http.StatusUnauthorized, "A valid bearer token is required.", nil)
+	}
+}
+
+// requestParams returns the settings of the request, normalized with normalizeParams.
+// The invalid parameters are reported in the error, and left out of the settings.
+// The query parameters override the ones of the profile selected with the profile parameter,
+// which in turn override the configured defaults.
+func (s *Server) requestParams(r *http.Request) (map[string]string, error) {
+	query, err := normalizeParams(urlQueryToMap(r.URL.Query()))
+	// the configuration was validated, so normalizing it cannot fail
+	defaults, _ := normalizeParams(s.config.Defaults)
+	layers := []map[string]string{defaults}
+	if name := query["profile"]; name != "" {
+		if profile, ok := s.config.Profiles[name]; ok {
+			profile, _ = normalizeParams(profile)
+			layers = append(layers, profile)
+		} else {
+			err = errors.Join(err, &ParamError{Name: "profile", Reason: fmt.Sprintf("unknown profile %q", name)})
+		}
+	}
+	layers = append(layers, query)
+
+	params := mergeParams(layers...)
+	delete(params, "profile")
+	if params["output"] == "" && s.config.Output.Default != "" {
+		params["output"] = s.config.Output.Default
+	}
+	if s.config.Sanitize.Always {
+		params["sanitize"] = "true"
+	}
+	return params, err
+}
+
+// outputParams returns the parameters choosing the output of the PDF, without its print settings.
+func outputParams(params map[string]string) map[string]string {
+	output := map[string]string{}
+	for _, key := range []string{"output", "filename", "sanitize"} {
+		if value, ok := params[key]; ok {
+			output[key] = value
+		}
+	}
+	return output
+}
+
+// mergeParams merges the settings, the later ones taking precedence.
+func mergeParams(layers ...map[string]string) map[string]string {
+	params := map[string]string{}
+	for _, layer := range layers {
+		for k, v := range layer {
+			params[k] = v
+		}
+	}
+	return params
+}
+
+// handleProfiles lists the configured profiles with their settings.
+func (s *Server) handleProfiles(w http.ResponseWriter, r *http.Request) {
+	profiles := s.config.Profiles
+	if profiles == nil {
+		profiles = map[string]map[string]string{}
+	}
+	writeJSON(w, http.StatusOK, profiles)
 }
 
 func readRequest(r io.ReadCloser) ([]byte, error) {
@@ -34,69 +387,304 @@ func readRequest(r io.ReadCloser) ([]byte, error) {
 }
 
 func convertHTMLServerHandler(chromePath string) func(w http.ResponseWriter, r *http.Request) {
-	return func(w http.ResponseWriter, r *http.Request) {
-		if err := validateConvertHTMLRequest(w, r); err != nil {
-			log.Println(err)
+	return NewServer(0, chromePath).handleConvert
+}
+
+func (s *Server) handleConvert(w http.ResponseWriter, r *http.Request) {
+	requestID := requestIDFromRequest(r)
+	w.Header().Set(RequestIDHeader, requestID)
+	logger := loggerWithRequestID(requestID)
+
+	if s.isDraining() {
+		w.Header().Set("Connection", "close")
+		writeProblem(w, r, http.StatusServiceUnavailable, "The server is shutting down.", nil)
+		return
+	}
+	start := time.Now()
+	outcome := outcomeInvalidRequest
+	params, paramsErr := s.requestParams(r)
+
+	// continue the trace of the client, if any
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer().Start(ctx, "lazypress.convert",
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attribute.String("lazypress.request_id", requestID)),
+	)
+	defer func() {
+		metrics.conversion(outcome, params["output"])
+		logger.Info("conversion finished", "outcome", outcome, "duration", time.Since(start))
+		span.SetAttributes(attribute.String("lazypress.outcome", outcome))
+		if outcome != outcomeSuccess {
+			span.SetStatus(codes.Error, outcome)
+		}
+		span.End()
+	}()
+
+	_, validateSpan := startSpan(ctx, "lazypress.validate")
+	err := validateConvertHTMLRequest(w, r)
+	endSpan(validateSpan, err)
+	if err != nil {
+		logger.Warn("invalid request", "error", err)
+		return
+	}
+	// in lenient mode, the invalid parameters are ignored like lazypress always did
+	lenient, _ := strconv.ParseBool(params["lenient"])
+	if paramsErr != nil {
+		if !lenient {
+			logger.Warn("invalid request", "error", paramsErr)
+			writeProblem(w, r, http.StatusBadRequest, "The request has invalid parameters.", paramsErr)
 			return
 		}
-		var p PDF
+		logger.Warn("ignoring invalid parameters", "error", paramsErr)
+	}
+	if output := strings.ToLower(params["output"]); len(s.config.Output.Allowed) > 0 && !slices.Contains(s.config.Output.Allowed, output) {
+		writeProblem(w, r, http.StatusBadRequest, "The output is not allowed.", &ParamError{Name: "output", Reason: fmt.Sprintf("%s is not allowed", output)})
+		return
+	}
+	if s.config.Limits.MaxBodyBytes > 0 {
+		r.Body = http.MaxBytesReader(w, r.Body, s.config.Limits.MaxBodyBytes)
+	}
+	p := PDF{RequestID: requestID, exporters: s.exporters, Limits: s.config.Limits.renderLimits()}
+	// the export is only opened once there is a PDF, but a failed or streamed render can leave it half written
+	defer func() {
+		if outcome != outcomeSuccess {
+			p.AbortExport()
+		}
+	}()
 
-		params := urlQueryToMap(r.URL.Query())
-		if err := p.LoadSettings(params, w, nil); err != nil {
-			// we just log the error and continue with defaults
-			log.Println(err)
-			p.Settings = page.PrintToPDFParams{}
+	if err := p.LoadSettings(params, w, nil); err != nil {
+		if !lenient {
+			logger.Warn("invalid request", "error", err)
+			writeProblem(w, r, http.StatusBadRequest, "The request has invalid settings.", err)
+			return
 		}
-		body, err := readRequest(r.Body)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		// we just log the error and continue with defaults
+		logger.Warn("could not load settings, using defaults", "error", err)
+		p = PDF{RequestID: requestID, exporters: s.exporters, Limits: s.config.Limits.renderLimits()}
+		// the output cannot be left out, so the request fails when it is the invalid setting
+		if err := p.LoadSettings(outputParams(params), w, nil); err != nil {
+			logger.Warn("invalid request", "error", err)
+			writeProblem(w, r, http.StatusBadRequest, "The request has invalid settings.", err)
+			return
+		}
+	}
+	req, err := readConvertRequest(r)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeProblem(w, r, http.StatusRequestEntityTooLarge, err.Error(), nil)
+			return
+		}
+		if len(paramErrors(err)) > 0 {
+			logger.Warn("invalid request", "error", err)
+			writeProblem(w, r, http.StatusBadRequest, "The request has an invalid body.", err)
 			return
 		}
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	body := req.html
+	if len(body) == 0 {
+		writeProblem(w, r, http.StatusBadRequest, "Body is empty", nil)
+		return
+	}
+	// the sanitizer would remove the classes of the highlighted code of Markdown documents, so their raw HTML
+	// is left out instead, and plain text is escaped anyway
+	body, p.Properties, err = renderDocument(req, params, p.Sanitize)
+	if err != nil {
+		logger.Warn("invalid request", "error", err)
+		writeProblem(w, r, http.StatusBadRequest, "The request has an invalid document.", err)
+		return
+	}
+	if p.Sanitize && req.format != formatMarkdown && req.format != formatText {
+		_, sanitizeSpan := startSpan(ctx, "lazypress.sanitize")
+		size := len(body)
+		body = sanitizeHTMLWithPolicy(body, s.config.Sanitize.Policy)
+		metrics.observeSanitization(size, len(body))
+		sanitizeSpan.SetAttributes(attribute.Int("lazypress.removed_bytes", size-len(body)))
+		sanitizeSpan.End()
 		if len(body) == 0 {
-			http.Error(w, "Body is empty", http.StatusBadRequest)
+			writeProblem(w, r, http.StatusBadRequest, "Body is empty", nil)
 			return
 		}
-		if p.Sanitize {
-			body = SanitizeHTML(body)
-			if len(body) == 0 {
-				http.Error(w, "Body is empty", http.StatusBadRequest)
+	}
+
+	if err := s.applyHeaderFooter(&p, params, req); err != nil {
+		if !lenient {
+			logger.Warn("invalid request", "error", err)
+			writeProblem(w, r, http.StatusBadRequest, "The request has invalid templates.", err)
+			return
+		}
+		logger.Warn("ignoring invalid templates", "error", err)
+	}
+	p.ExpandTemplates(body)
+	if s.fonts != nil {
+		if css, err := s.fonts.FontFaceCSS(); err != nil {
+			logger.Warn("could not load fonts", "error", err)
+		} else {
+			p.FontFaces = css
+		}
+	}
+	// in JSON mode, the PDF is described along with its content, so it cannot be streamed
+	infoJSON, _ := strconv.ParseBool(params["info"])
+	if infoJSON {
+		p.Stream = false
+		p.Settings.TransferMode = ""
+	}
+
+	cached := s.lookupCache(r, params, &p, body)
+	if cached.key != "" {
+		w.Header().Set("X-Cache", strings.ToUpper(cached.status))
+		if output := strings.ToLower(params["output"]); output == "" || output == "download" {
+			etag := `"` + cached.key + `"`
+			w.Header().Set("ETag", etag)
+			w.Header().Set("Cache-Control", fmt.Sprintf("private, max-age=%d", int(s.config.Cache.TTL.Seconds())))
+			// the key identifies the PDF, so the client already has it, unless it asked to bypass the cache.
+			// Conversions are POSTs, for which a matching If-None-Match fails with 412, not 304 (RFC 9110, 13.1.2).
+			if cached.status != cacheBypass && ifNoneMatch(r, etag, cached.status == cacheHit) {
+				outcome = outcomeSuccess
+				writeProblem(w, r, http.StatusPreconditionFailed, "The PDF matches If-None-Match: the client already has it.", nil)
 				return
 			}
 		}
+	}
 
-		var allocatorCtx context.Context
-		var allocatorCancel context.CancelFunc
-
-		if chromePath != "" {
-			opt := []func(allocator *chromedp.ExecAllocator){
-				chromedp.ExecPath(chromePath),
+	if cached.status != cacheHit {
+		queueStart := time.Now()
+		release, err := s.waitForRender(ctx, params)
+		p.Timings.Queue = time.Since(queueStart)
+		if err != nil {
+			outcome = outcomeRejected
+			logger.Warn("conversion rejected", "error", err, "priority", params["priority"])
+			if s.config.Queue.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(s.config.Queue.RetryAfter.Seconds()))))
 			}
-			// create context
-			allocatorCtx, allocatorCancel = chromedp.NewExecAllocator(
-				context.Background(),
-				append(opt, chromedp.DefaultExecAllocatorOptions[:]...)[:]...,
-			)
-			defer allocatorCancel()
-		} else {
-			allocatorCtx = context.Background()
+			detail := "The render queue is full."
+			if errors.Is(err, errQueueTimeout) {
+				detail = "Timed out waiting in the render queue."
+			}
+			writeProblem(w, r, http.StatusServiceUnavailable, detail, nil)
+			return
 		}
-
-		p.GenerateWithChrome(allocatorCtx, body)
-		if p.Content == nil {
-			log.Println("Could not generate PDF")
-			http.Error(w, "Could not generate PDF", http.StatusInternalServerError)
+		defer release()
+		done, err := s.trackRender(len(body))
+		if err != nil {
+			outcome = outcomeRejected
+			w.Header().Set("Connection", "close")
+			writeProblem(w, r, http.StatusServiceUnavailable, "The server is shutting down.", nil)
 			return
 		}
-		if err := p.Export(); err != nil {
-			log.Println(err)
-			http.Error(w, err.Error(), http.StatusInternalServerError)
+		defer done()
+
+		allocatorCtx, allocatorCancel := s.newAllocator()
+		defer allocatorCancel()
+
+		// the render is bound to the server's lifetime, but belongs to the request's trace
+		renderCtx := trace.ContextWithSpan(WithRequestID(allocatorCtx, requestID), span)
+		if err := p.generateWithChrome(renderCtx, body); err != nil || (p.Content == nil && !p.streamed) {
+			status, detail := http.StatusInternalServerError, "Could not generate PDF"
+			outcome = outcomeRenderError
+			if exceededLimit(err) != "" {
+				status, detail = limitStatus(err), err.Error()
+				outcome = outcomeLimitExceeded
+			}
+			logger.Error("could not generate PDF", "error", err)
+			if p.streamed {
+				// the response has started, abort it so that the client does not take a truncated PDF for a complete one
+				panic(http.ErrAbortHandler)
+			}
+			writeProblem(w, r, status, detail, err)
 			return
 		}
-		if p.filePath != "" {
-			w.Header().Add("Content-Type", "application/json")
-			w.Write([]byte(fmt.Sprintf("{\"file\": \"%s\"}", p.filePath)))
+		// a streamed PDF is not kept in memory, so it cannot be cached
+		if cached.store && p.Content != nil {
+			s.cache.Set(cached.key, p.Content)
 		}
 	}
+	var info *DocumentInfo
+	if p.Content != nil {
+		if i, err := p.Info(); err == nil {
+			info = &i
+			info.setHeaders(w.Header())
+		} else {
+			logger.Warn("could not describe PDF", "error", err)
+		}
+	}
+	if output := strings.ToLower(params["output"]); infoJSON && (output == "" || output == "download") {
+		outcome = outcomeSuccess
+		writeJSON(w, http.StatusOK, convertResponse{
+			ExportResult: &ExportResult{Output: "download", Bytes: int64(len(p.Content)), ContentType: PDFContentType, RequestID: requestID},
+			Info:         info,
+			Content:      p.Content,
+		})
+		return
+	}
+	result, err := p.ExportWithResult(ctx)
+	if err != nil {
+		outcome = outcomeExportError
+		logger.Error("could not export PDF", "error", err)
+		writeProblem(w, r, http.StatusInternalServerError, err.Error(), nil)
+		return
+	}
+	outcome = outcomeSuccess
+	if result != nil && result.Output == "file" {
+		result.Location = s.fileURL(result.ID, time.Now())
+	}
+	if result != nil && !result.Inline {
+		writeJSON(w, http.StatusOK, convertResponse{ExportResult: result, Info: info})
+	}
+}
+
+// limitStatus returns the status code of the responses to the conversions exceeding a limit.
+func limitStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrHTMLTooLarge):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, ErrTooManyPages), errors.Is(err, ErrPDFTooLarge):
+		return http.StatusUnprocessableEntity
+	default:
+		return http.StatusGatewayTimeout
+	}
+}
+
+// waitForRender waits for the render queue to let the conversion through, for the queue timeout at most.
+// The timeout can be shortened with the queueTimeout parameter.
+func (s *Server) waitForRender(ctx context.Context, params map[string]string) (func(), error) {
+	_, span := startSpan(ctx, "lazypress.queue", attribute.String("lazypress.priority", params["priority"]))
+	timeout := s.config.Queue.Timeout
+	if d, err := time.ParseDuration(params["queueTimeout"]); err == nil && d > 0 && (timeout == 0 || d < timeout) {
+		timeout = d
+	}
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	// stop waiting when the server shuts down
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(s.baseCtx, cancel)
+	defer stop()
+
+	release, err := s.queue.acquire(ctx, params["priority"])
+	endSpan(span, err)
+	switch {
+	case errors.Is(err, errQueueFull):
+		metrics.queueRejects.WithLabelValues("full").Inc()
+	case err != nil:
+		metrics.queueRejects.WithLabelValues("timeout").Inc()
+	}
+	return release, err
+}
+
+// requestIDFromRequest returns the ID sent by the client in the X-Request-ID header,
+// or a new one if there is none (or it is unreasonably long).
+func requestIDFromRequest(r *http.Request) string {
+	id := r.Header.Get(RequestIDHeader)
+	if id == "" || len(id) > 128 {
+		return newRequestID()
+	}
+	return id
 }
 
 func urlQueryToMap(query url.Values) map[string]string {
@@ -107,25 +695,28 @@ func urlQueryToMap(query url.Values) map[string]string {
 	return params
 }
 
+// convertContentTypes are the content types of the bodies accepted by /convert.
+var convertContentTypes = []string{"text/html", MarkdownContentType, PlainTextContentType, EmailContentType, MHTMLContentType, "multipart/form-data"}
+
 func validateConvertHTMLRequest(w http.ResponseWriter, r *http.Request) error {
 	contentType := r.Header.Get("Content-Type")
 	contentLength := r.Header.Get("Content-Length")
 
 	if r.Method != "POST" {
 		errMsg := "method not allowed"
-		http.Error(w, errMsg, http.StatusMethodNotAllowed)
+		writeProblem(w, r, http.StatusMethodNotAllowed, errMsg, nil)
 		return errors.New(errMsg)
 	}
 
-	if contentType != "text/plain" && contentType != "text/html" {
-		errMsg := "content-type must be text/plain or text/html"
-		http.Error(w, errMsg, http.StatusBadRequest)
+	if mediaType, _, _ := mime.ParseMediaType(contentType); !slices.Contains(convertContentTypes, mediaType) {
+		errMsg := "content-type must be one of " + strings.Join(convertContentTypes, ", ")
+		writeProblem(w, r, http.StatusBadRequest, errMsg, nil)
 		return errors.New(errMsg)
 	}
 
 	if contentLength == "" || contentLength == "0" {
 		errMsg := "content-length must be set"
-		http.Error(w, errMsg, http.StatusBadRequest)
+		writeProblem(w, r, http.StatusBadRequest, errMsg, nil)
 		return errors.New(errMsg)
 	}
 
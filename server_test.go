@@ -1,6 +1,8 @@
 ﻿package lazypress
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +11,7 @@ import (
 	"path"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestShouldDownloadPDFWhenNoOutputParam(t *testing.T) {
@@ -157,3 +160,77 @@ func TestShouldNotReturnErrorIfSanitizationIsOffAndContentIsAllScript(t *testing
 		t.Errorf("Expected Content-Type to be application/pdf, got %s", result.Header.Get("Content-Type"))
 	}
 }
+
+func TestShouldRefuseConversionsWhenShuttingDown(t *testing.T) {
+	s := NewServer(0, "")
+	if err := s.Shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+	html := `<html><body>Hello World</body></html>`
+	req, err := http.NewRequest("POST", "/convert", strings.NewReader(html))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "text/html")
+	req.Header.Set("Content-Length", fmt.Sprint((len(html))))
+	w := httptest.NewRecorder()
+	s.handleConvert(w, req)
+	result := w.Result()
+	defer result.Body.Close()
+	if result.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code to be 503, got %d", result.StatusCode)
+	}
+}
+
+func TestShouldWaitForTrackedRendersOnShutdown(t *testing.T) {
+	s := NewServer(0, "")
+	done, err := s.trackRender(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopped := make(chan struct{})
+	go func() {
+		s.Shutdown(context.Background())
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Expected Shutdown to wait for the render in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if _, err := s.trackRender(0); !errors.Is(err, errServerDraining) {
+		t.Errorf("Expected no render to be tracked once the server is draining, got %v", err)
+	}
+	done()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Error("Expected Shutdown to return once the render is over")
+	}
+}
+
+func TestShouldStopServerOnShutdown(t *testing.T) {
+	s := NewServer(0, "")
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- s.Start()
+	}()
+	// give the server some time to start listening
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Error(err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("Expected no error when server is shut down, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Error("Expected server to stop after shutdown")
+	}
+	if err := s.Start(); err != http.ErrServerClosed {
+		t.Errorf("Expected server not to restart after shutdown, got %v", err)
+	}
+}
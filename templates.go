@@ -0,0 +1,281 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	nethtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// TemplateData holds the values of the {{name}} placeholders of the header and footer templates:
+//   - {{title}}: the title of the document
+//   - {{meta.NAME}}: the content of the meta tag of the document with this name, e.g. {{meta.author}}
+//   - {{var.NAME}}: a custom value, given to the server with a var.NAME parameter
+//   - {{date}}, {{shortDate}}, {{time}} and {{year}}: the current date and time, formatted for the locale
+//   - {{date:LAYOUT}}: the current date in a Go time layout, e.g. {{date:Monday 2 January 2006}}
+//
+// The values are HTML-escaped. Other placeholders are left as they are.
+// Chrome's own classes (pageNumber, totalPages, etc.) keep working alongside them.
+type TemplateData struct {
+	// Metadata of the document: its title and its meta tags, by name. See DocumentMetadata.
+	Metadata map[string]string
+	// Vars are custom values, by name.
+	Vars map[string]string
+	// Now is the time of the dates. It defaults to the current time.
+	Now time.Time
+	// Location is the time zone of the dates. It defaults to the location of Now.
+	Location *time.Location
+	// Locale is the language and region of the dates, e.g. fr or en-GB. It defaults to en. See Locales.
+	Locale string
+}
+
+// templatePlaceholder matches the placeholders of the templates, with the layout of {{date:LAYOUT}}.
+var templatePlaceholder = regexp.MustCompile(`\{\{\s*([A-Za-z][\w.-]*)(?::([^}]*))?\s*\}\}`)
+
+// Expand replaces the placeholders of the template with their values.
+func (d TemplateData) Expand(template string) string {
+	now := d.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if d.Location != nil {
+		now = now.In(d.Location)
+	}
+	locale := lookupLocale(d.Locale)
+	return templatePlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		match := templatePlaceholder.FindStringSubmatch(placeholder)
+		name, layout := match[1], match[2]
+		var value string
+		switch {
+		case name == "title":
+			value = d.Metadata["title"]
+		case strings.HasPrefix(name, "meta."):
+			value = d.Metadata[strings.ToLower(strings.TrimPrefix(name, "meta."))]
+		case strings.HasPrefix(name, "var."):
+			value = d.Vars[strings.TrimPrefix(name, "var.")]
+		case name == "date" && layout != "":
+			value = locale.format(now, layout)
+		case name == "date":
+			value = locale.format(now, locale.long)
+		case name == "shortDate":
+			value = locale.format(now, locale.short)
+		case name == "time":
+			value = locale.format(now, locale.time)
+		case name == "year":
+			value = now.Format("2006")
+		default:
+			return placeholder
+		}
+		return html.EscapeString(value)
+	})
+}
+
+// DocumentMetadata returns the title of the HTML document, under the "title" key,
+// and the content of its meta tags by name, in lower case (e.g. "author").
+func DocumentMetadata(document []byte) map[string]string {
+	metadata := map[string]string{}
+	z := nethtml.NewTokenizer(bytes.NewReader(document))
+	inTitle := false
+	for {
+		switch z.Next() {
+		case nethtml.ErrorToken:
+			return metadata
+		case nethtml.StartTagToken, nethtml.SelfClosingTagToken:
+			token := z.Token()
+			switch token.DataAtom {
+			case atom.Title:
+				inTitle = true
+			case atom.Meta:
+				var name, content string
+				for _, attr := range token.Attr {
+					switch attr.Key {
+					case "name":
+						name = strings.ToLower(attr.Val)
+					case "content":
+						content = attr.Val
+					}
+				}
+				if _, ok := metadata[name]; name != "" && !ok {
+					metadata[name] = content
+				}
+			case atom.Body:
+				// the metadata is in the head
+				return metadata
+			}
+		case nethtml.TextToken:
+			if _, ok := metadata["title"]; inTitle && !ok {
+				metadata["title"] = strings.TrimSpace(string(z.Text()))
+			}
+		case nethtml.EndTagToken:
+			inTitle = false
+		}
+	}
+}
+
+// dateLocale holds how the dates are written in a locale.
+type dateLocale struct {
+	months [12]string
+	days   [7]string
+	// long, short and time are the Go time layouts of {{date}}, {{shortDate}} and {{time}}
+	long, short, time string
+}
+
+var englishMonths = [12]string{"January", "February", "March", "April", "May", "June", "July", "August", "September", "October", "November", "December"}
+var englishDays = [7]string{"Sunday", "Monday", "Tuesday", "Wednesday", "Thursday", "Friday", "Saturday"}
+
+var dateLocales = map[string]dateLocale{
+	"en":    {englishMonths, englishDays, "January 2, 2006", "01/02/2006", "3:04 PM"},
+	"en-gb": {englishMonths, englishDays, "2 January 2006", "02/01/2006", "15:04"},
+	"fr": {
+		[12]string{"janvier", "février", "mars", "avril", "mai", "juin", "juillet", "août", "septembre", "octobre", "novembre", "décembre"},
+		[7]string{"dimanche", "lundi", "mardi", "mercredi", "jeudi", "vendredi", "samedi"},
+		"2 January 2006", "02/01/2006", "15:04",
+	},
+	"de": {
+		[12]string{"Januar", "Februar", "März", "April", "Mai", "Juni", "Juli", "August", "September", "Oktober", "November", "Dezember"},
+		[7]string{"Sonntag", "Montag", "Dienstag", "Mittwoch", "Donnerstag", "Freitag", "Samstag"},
+		"2. January 2006", "02.01.2006", "15:04",
+	},
+	"it": {
+		[12]string{"gennaio", "febbraio", "marzo", "aprile", "maggio", "giugno", "luglio", "agosto", "settembre", "ottobre", "novembre", "dicembre"},
+		[7]string{"domenica", "lunedì", "martedì", "mercoledì", "giovedì", "venerdì", "sabato"},
+		"2 January 2006", "02/01/2006", "15:04",
+	},
+	"es": {
+		[12]string{"enero", "febrero", "marzo", "abril", "mayo", "junio", "julio", "agosto", "septiembre", "octubre", "noviembre", "diciembre"},
+		[7]string{"domingo", "lunes", "martes", "miércoles", "jueves", "viernes", "sábado"},
+		"2 de January de 2006", "02/01/2006", "15:04",
+	},
+	"pt": {
+		[12]string{"janeiro", "fevereiro", "março", "abril", "maio", "junho", "julho", "agosto", "setembro", "outubro", "novembro", "dezembro"},
+		[7]string{"domingo", "segunda-feira", "terça-feira", "quarta-feira", "quinta-feira", "sexta-feira", "sábado"},
+		"2 de January de 2006", "02/01/2006", "15:04",
+	},
+	"nl": {
+		[12]string{"januari", "februari", "maart", "april", "mei", "juni", "juli", "augustus", "september", "oktober", "november", "december"},
+		[7]string{"zondag", "maandag", "dinsdag", "woensdag", "donderdag", "vrijdag", "zaterdag"},
+		"2 January 2006", "02-01-2006", "15:04",
+	},
+}
+
+// Locales returns the locales of the dates of the templates.
+func Locales() []string {
+	locales := make([]string, 0, len(dateLocales))
+	for locale := range dateLocales {
+		locales = append(locales, locale)
+	}
+	sort.Strings(locales)
+	return locales
+}
+
+// validLocale reports whether the dates can be written in the locale.
+func validLocale(locale string) bool {
+	_, ok := findLocale(locale)
+	return ok
+}
+
+// findLocale returns the dateLocale of a locale like en-GB or fr_CA, falling back on its language.
+func findLocale(locale string) (dateLocale, bool) {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if l, ok := dateLocales[locale]; ok {
+		return l, true
+	}
+	language, _, _ := strings.Cut(locale, "-")
+	l, ok := dateLocales[language]
+	return l, ok
+}
+
+func lookupLocale(locale string) dateLocale {
+	if l, ok := findLocale(locale); ok {
+		return l
+	}
+	return dateLocales["en"]
+}
+
+// Markers replacing the names of the months and days in the layouts, as time.Format leaves them alone.
+const (
+	monthMarker      = "\x01"
+	shortMonthMarker = "\x02"
+	dayMarker        = "\x03"
+	shortDayMarker   = "\x04"
+)
+
+// format formats t with a Go time layout, writing the names of the months and days in the language of the locale.
+func (l dateLocale) format(t time.Time, layout string) string {
+	layout = strings.NewReplacer(
+		"January", monthMarker, "Jan", shortMonthMarker,
+		"Monday", dayMarker, "Mon", shortDayMarker,
+	).Replace(layout)
+	month, day := l.months[t.Month()-1], l.days[t.Weekday()]
+	return strings.NewReplacer(
+		monthMarker, month, shortMonthMarker, abbreviate(month),
+		dayMarker, day, shortDayMarker, abbreviate(day),
+	).Replace(t.Format(layout))
+}
+
+// abbreviate returns the first three letters of a name.
+func abbreviate(name string) string {
+	for i := range name {
+		if utf8.RuneCountInString(name[:i]) == 3 {
+			return name[:i]
+		}
+	}
+	return name
+}
+
+// ExpandTemplates replaces the placeholders of the header and footer templates, including the PageTemplates,
+// and of the template of the TOC with the values of TemplateData. It sets the metadata of TemplateData from the HTML document when it has none.
+// The templates are expanded once: GenerateWithChrome and GenerateFromURL do it when it was not done before.
+func (p *PDF) ExpandTemplates(html []byte) {
+	if p.templatesExpanded {
+		return
+	}
+	p.templatesExpanded = true
+	if p.TemplateData.Metadata == nil && html != nil {
+		p.TemplateData.Metadata = DocumentMetadata(html)
+	}
+	expand := func(template *string) {
+		if *template != "" {
+			*template = p.TemplateData.Expand(*template)
+		}
+	}
+	expand(&p.Settings.HeaderTemplate)
+	expand(&p.Settings.FooterTemplate)
+	p.PageTemplates.each(expand)
+	expand(&p.TOC.Template)
+}
+
+// validTemplateName reports whether name is a relative path to a file of a template directory, e.g. acme/footer.html.
+func validTemplateName(name string) bool {
+	return fs.ValidPath(name) && name != "."
+}
+
+// loadTemplateParams reads the template parameters: the var.NAME values, the timezone and the locale.
+func loadTemplateParams(params map[string]string) (TemplateData, error) {
+	var data TemplateData
+	for key, value := range params {
+		if name, ok := strings.CutPrefix(key, varParamPrefix); ok {
+			if data.Vars == nil {
+				data.Vars = map[string]string{}
+			}
+			data.Vars[name] = value
+		}
+	}
+	if timezone := params["timezone"]; timezone != "" {
+		location, err := time.LoadLocation(timezone)
+		if err != nil {
+			return data, &ParamError{Name: "timezone", Reason: fmt.Sprintf("unknown time zone %q", timezone)}
+		}
+		data.Location = location
+	}
+	data.Locale = params["locale"]
+	return data, nil
+}
@@ -0,0 +1,159 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestShouldExpandTemplatePlaceholders(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	data := TemplateData{
+		Metadata: map[string]string{"title": "Q1 <Report>", "author": "Jane"},
+		Vars:     map[string]string{"client": "ACME & Co"},
+		Now:      time.Date(2024, time.March, 4, 23, 30, 0, 0, time.UTC),
+		Location: paris,
+		Locale:   "fr-CA",
+	}
+	for template, expected := range map[string]string{
+		"{{title}}":                      "Q1 &lt;Report&gt;",
+		"{{ meta.author }}":              "Jane",
+		"{{meta.missing}}":               "",
+		"{{var.client}}":                 "ACME &amp; Co",
+		"{{date}}":                       "5 mars 2024",
+		"{{shortDate}}":                  "05/03/2024",
+		"{{time}}":                       "00:30",
+		"{{year}}":                       "2024",
+		"{{date:Monday 2 Jan}}":          "mardi 5 mar",
+		"<span class=pageNumber></span>": "<span class=pageNumber></span>",
+		"{{unknown}}":                    "{{unknown}}",
+	} {
+		if actual := data.Expand(template); actual != expected {
+			t.Errorf("Expected %s to expand to %q, got %q", template, expected, actual)
+		}
+	}
+
+	data.Locale = ""
+	if actual := data.Expand("{{date}} {{time}}"); actual != "March 5, 2024 12:30 AM" {
+		t.Errorf("Expected the dates to default to English, got %q", actual)
+	}
+}
+
+func TestShouldReadDocumentMetadata(t *testing.T) {
+	metadata := DocumentMetadata([]byte(`<html><head><title> Q1 Report </title><meta name="Author" content="Jane"><meta charset="utf-8"></head>
+<body><meta name="author" content="ignored"></body></html>`))
+	if metadata["title"] != "Q1 Report" || metadata["author"] != "Jane" || len(metadata) != 2 {
+		t.Errorf("Expected the title and author, got %v", metadata)
+	}
+}
+
+func TestShouldExpandTemplatesWithTheSettings(t *testing.T) {
+	var p PDF
+	err := p.LoadSettings(map[string]string{
+		"headerTemplate": "<span>{{title}} for {{var.Client}}</span>",
+		"VAR.Client":     "ACME",
+		"locale":         "de",
+		"timezone":       "UTC",
+	}, io.Discard, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.ExpandTemplates([]byte("<html><head><title>Invoice</title></head></html>"))
+	if p.Settings.HeaderTemplate != "<span>Invoice for ACME</span>" {
+		t.Errorf("Expected the header to be expanded, got %q", p.Settings.HeaderTemplate)
+	}
+
+	for name, params := range map[string]map[string]string{
+		"timezone":   {"timezone": "Mars/Olympus"},
+		"locale":     {"locale": "tlh"},
+		"headerFile": {"headerFile": "../secrets.html"},
+	} {
+		if errs := paramErrors(validateParams(params)); len(errs) == 0 || errs[0].Name != name {
+			t.Errorf("Expected %v to be reported as an invalid %s, got %v", params, name, errs)
+		}
+	}
+}
+
+func newMultipartRequest(t *testing.T, target string, parts map[string]string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	for name, content := range parts {
+		if err := form.WriteField(name, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	form.Close()
+	r := httptest.NewRequest("POST", target, &body)
+	r.Header.Set("Content-Type", form.FormDataContentType())
+	r.Header.Set("Content-Length", strconv.Itoa(body.Len()))
+	return r
+}
+
+func TestShouldReadTemplatesFromMultipartRequests(t *testing.T) {
+	r := newMultipartRequest(t, "/convert", map[string]string{
+		"html":   "<html><body>Hello World</body></html>",
+		"footer": "<span>{{var.client}}</span>",
+	})
+	req, err := readConvertRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(req.html) != "<html><body>Hello World</body></html>" || len(req.templates) != 1 {
+		t.Errorf("Expected the HTML and the footer, got %+v", req)
+	}
+
+	s := newServer(DefaultConfig())
+	var p PDF
+	if err := s.applyHeaderFooter(&p, map[string]string{}, req); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Settings.DisplayHeaderFooter || p.Settings.HeaderTemplate != "<span></span>" || p.Settings.FooterTemplate != "<span>{{var.client}}</span>" {
+		t.Errorf("Expected the footer to be displayed, got %+v", p.Settings)
+	}
+
+	w := httptest.NewRecorder()
+	s.handleConvert(w, newMultipartRequest(t, "/convert", map[string]string{"html": "<html></html>", "cover": "<html></html>"}))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status code to be 400 for an unknown part, got %d", w.Code)
+	}
+}
+
+func TestShouldReadTemplatesFromTheTemplateDirectory(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFile(t, filepath.Join(dir, "acme", "header.html"), "<span>ACME</span>")
+	writeTestFile(t, filepath.Join(filepath.Dir(dir), "secret.html"), "secret")
+	cfg := DefaultConfig()
+	cfg.Templates.Dir = dir
+	s := newServer(cfg)
+
+	var p PDF
+	if err := s.applyHeaderFooter(&p, map[string]string{"headerFile": "acme/header.html", "displayHeaderFooter": "false"}, convertRequest{}); err != nil {
+		t.Fatal(err)
+	}
+	if p.Settings.HeaderTemplate != "<span>ACME</span>" || p.Settings.DisplayHeaderFooter {
+		t.Errorf("Expected the header of the file, got %+v", p.Settings)
+	}
+
+	for _, name := range []string{"missing.html", "../secret.html"} {
+		err := s.applyHeaderFooter(&p, map[string]string{"footerFile": name}, convertRequest{})
+		if errs := paramErrors(err); len(errs) != 1 || errs[0].Name != "footerFile" {
+			t.Errorf("Expected %s to be rejected, got %v", name, err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(filepath.Dir(dir), "secret.html"), filepath.Join(dir, "link.html")); err == nil {
+		if err := s.applyHeaderFooter(&p, map[string]string{"footerFile": "link.html"}, convertRequest{}); err == nil {
+			t.Error("Expected a link out of the template directory to be rejected")
+		}
+	}
+}
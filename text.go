@@ -0,0 +1,58 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"strings"
+)
+
+// PlainTextContentType is the content type of the plain text documents, e.g. logs.
+const PlainTextContentType = "text/plain"
+
+// plainTextCSS is the stylesheet of the plain text documents: the lines wrap instead of running off the page.
+const plainTextCSS = `body { margin: 0; }
+pre.plain-text { margin: 0; font: 9pt/1.4 ui-monospace, "DejaVu Sans Mono", Menlo, Consolas, monospace; white-space: pre-wrap; overflow-wrap: anywhere; tab-size: 4; }
+pre.plain-text .line { display: block; break-inside: avoid; }
+pre.plain-text.numbered { counter-reset: line; }
+pre.plain-text.numbered .line { padding-left: 5em; text-indent: -5em; }
+pre.plain-text.numbered .line::before { counter-increment: line; content: counter(line); display: inline-block; width: 4em; margin-right: 1em; text-indent: 0; text-align: right; color: #999; user-select: none; }
+`
+
+// TextOptions are the options of RenderPlainText.
+type TextOptions struct {
+	// LineNumbers numbers the lines in the margin.
+	LineNumbers bool
+}
+
+// RenderPlainText renders a plain text document to a standalone HTML document, in a monospace font,
+// with the long lines wrapped. The text is expected to be UTF-8, invalid bytes are replaced.
+func RenderPlainText(text []byte, opts TextOptions) []byte {
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html><head><meta charset=\"utf-8\"><style>")
+	out.WriteString(plainTextCSS)
+	out.WriteString("</style></head>\n<body>")
+	writePlainText(&out, text, opts)
+	out.WriteString("</body></html>\n")
+	return out.Bytes()
+}
+
+// writePlainText writes the text as an HTML pre element, with a span per line so that they can be numbered.
+func writePlainText(out *bytes.Buffer, text []byte, opts TextOptions) {
+	content := strings.ToValidUTF8(string(text), "\ufffd")
+	content = strings.TrimPrefix(content, "\ufeff")
+	content = strings.ReplaceAll(content, "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+	class := "plain-text"
+	if opts.LineNumbers {
+		class += " numbered"
+	}
+	fmt.Fprintf(out, `<pre class="%s">`, class)
+	for line := range strings.SplitSeq(content, "\n") {
+		out.WriteString(`<span class="line">`)
+		out.WriteString(html.EscapeString(line))
+		// an empty line still takes the height of a line
+		out.WriteString("\n</span>")
+	}
+	out.WriteString("</pre>")
+}
@@ -0,0 +1,51 @@
+﻿package lazypress
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestShouldRenderPlainText(t *testing.T) {
+	html := string(RenderPlainText([]byte("GET /index.html <200>\r\n\n\tdone\xff\n"), TextOptions{}))
+	for _, expected := range []string{
+		`<pre class="plain-text">`,
+		`<span class="line">GET /index.html &lt;200&gt;` + "\n</span>",
+		`<span class="line">` + "\n</span>",
+		`<span class="line">` + "\tdone�\n</span></pre>",
+		"white-space: pre-wrap",
+	} {
+		if !strings.Contains(html, expected) {
+			t.Errorf("Expected the HTML to contain %q, got %s", expected, html)
+		}
+	}
+	if strings.Count(html, `<span class="line">`) != 3 {
+		t.Errorf("Expected 3 lines, got %s", html)
+	}
+	if html := string(RenderPlainText([]byte("a\nb"), TextOptions{LineNumbers: true})); !strings.Contains(html, `<pre class="plain-text numbered">`) {
+		t.Errorf("Expected the lines to be numbered, got %s", html)
+	}
+}
+
+func TestShouldRenderDocumentsByContentType(t *testing.T) {
+	r := newConvertRequest(t, "/convert", "<b>not bold</b>")
+	r.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	req, err := readConvertRequest(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, _, err := renderDocument(req, map[string]string{"lineNumbers": "true"}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(html), "&lt;b&gt;not bold&lt;/b&gt;") || !strings.Contains(string(html), "numbered") {
+		t.Errorf("Expected the text to be escaped and numbered, got %s", html)
+	}
+
+	req, err = readConvertRequest(newMultipartRequest(t, "/convert", map[string]string{"email": "Subject: Hello\r\n\r\nHello World"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.format != formatEmail {
+		t.Errorf("Expected the email part to be read as an email, got %q", req.format)
+	}
+}
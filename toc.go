@@ -0,0 +1,278 @@
+﻿package lazypress
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"github.com/pdfcpu/pdfcpu/pkg/api"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/model"
+	"github.com/pdfcpu/pdfcpu/pkg/pdfcpu/types"
+)
+
+// DefaultTOCTitle is the title of the table of contents when TableOfContents.Title is empty.
+const DefaultTOCTitle = "Contents"
+
+// DefaultTOCLevels is the deepest heading level listed in the table of contents when TableOfContents.Levels is 0.
+const DefaultTOCLevels = 3
+
+// DefaultTOCTemplate is the template of the entries of the table of contents when TableOfContents.Template is empty.
+const DefaultTOCTemplate = `<li class="lazypress-toc-entry lazypress-toc-level-{{level}}"><a href="#{{id}}"><span class="lazypress-toc-heading">{{heading}}</span><span class="lazypress-toc-page">{{page}}</span></a></li>`
+
+// tocStyle is the default style of the table of contents. The document can override it with the same selectors.
+const tocStyle = `<style>
+.lazypress-toc { break-after: page }
+.lazypress-toc ol { list-style: none; margin: 0; padding: 0 }
+.lazypress-toc a { display: flex; color: inherit; text-decoration: none }
+.lazypress-toc-heading { flex: 1; overflow: hidden; white-space: nowrap }
+.lazypress-toc-heading::after { content: " . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . . ." }
+.lazypress-toc-page { padding-left: 0.5em }
+.lazypress-toc-level-2 { padding-left: 1.5em }
+.lazypress-toc-level-3 { padding-left: 3em }
+.lazypress-toc-level-4 { padding-left: 4.5em }
+.lazypress-toc-level-5 { padding-left: 6em }
+.lazypress-toc-level-6 { padding-left: 7.5em }
+</style>`
+
+// TableOfContents generates a table of contents from the headings of the document, with their page numbers.
+// The table replaces the content of the element with a data-toc attribute, or else it is inserted at the start of the body,
+// on its own pages.
+//
+// The headings are collected once the page is loaded, and those without an id are given one.
+// The document is then printed a first time with a placeholder table, to learn which page each heading lands on,
+// and printed again with the page numbers. The entries link to their heading.
+type TableOfContents struct {
+	Enabled bool
+	// Title is the title of the table. It defaults to DefaultTOCTitle.
+	Title string
+	// Levels is the deepest heading level listed, from 1 for the h1 headings only to 6. It defaults to DefaultTOCLevels.
+	Levels int
+	// Template is the HTML of an entry, with the {{heading}}, {{page}}, {{level}} and {{id}} placeholders.
+	// It defaults to DefaultTOCTemplate. Its links to #{{id}} are how the pages of the headings are found,
+	// so an entry without one has no page number. The placeholders of TemplateData are replaced too (see ExpandTemplates).
+	Template string
+}
+
+// TOCEntry is a heading listed in the table of contents.
+type TOCEntry struct {
+	Level   int    `json:"level"`
+	Heading string `json:"heading"`
+	ID      string `json:"id"`
+	// Page is the page of the heading, numbered from 1, or 0 when it is not known yet.
+	Page int `json:"page"`
+}
+
+// tocIDPrefix is the prefix of the ids given to the headings without one.
+const tocIDPrefix = "lazypress-toc-"
+
+// collectHeadingsScript returns the headings of the document up to a level, outside of the data-toc element,
+// and gives an id to the ones without one.
+const collectHeadingsScript = `(function(levels, prefix) {
+	const selector = Array.from({length: levels}, (_, i) => "h" + (i + 1)).join(",");
+	return Array.from(document.body ? document.body.querySelectorAll(selector) : [])
+		.filter(h => !h.closest("[data-toc], .lazypress-toc"))
+		.map((h, i) => {
+			if (!h.id) {
+				h.id = prefix + (i + 1);
+			}
+			return {level: Number(h.tagName.substring(1)), heading: h.textContent.replace(/\s+/g, " ").trim(), id: h.id, page: 0};
+		})
+		.filter(entry => entry.heading !== "");
+})`
+
+// insertTOCScript replaces the table of contents of the document with the given HTML.
+const insertTOCScript = `(function(toc) {
+	let target = document.querySelector(".lazypress-toc");
+	if (!target) {
+		const container = document.querySelector("[data-toc]");
+		target = document.createElement("nav");
+		if (container) {
+			container.replaceChildren(target);
+		} else {
+			document.body.prepend(target);
+		}
+	}
+	target.outerHTML = toc;
+	return true;
+})`
+
+// tocHTML returns the HTML of the table of contents with the given entries.
+func (t TableOfContents) tocHTML(entries []TOCEntry) string {
+	title := t.Title
+	if title == "" {
+		title = DefaultTOCTitle
+	}
+	template := t.Template
+	if template == "" {
+		template = DefaultTOCTemplate
+	}
+	var toc strings.Builder
+	toc.WriteString(`<nav class="lazypress-toc">`)
+	toc.WriteString(tocStyle)
+	toc.WriteString(`<div class="lazypress-toc-title">`)
+	toc.WriteString(html.EscapeString(title))
+	toc.WriteString(`</div><ol>`)
+	for _, entry := range entries {
+		page := ""
+		if entry.Page > 0 {
+			page = strconv.Itoa(entry.Page)
+		}
+		toc.WriteString(strings.NewReplacer(
+			"{{heading}}", html.EscapeString(entry.Heading),
+			"{{page}}", page,
+			"{{level}}", strconv.Itoa(entry.Level),
+			"{{id}}", html.EscapeString(entry.ID),
+		).Replace(template))
+	}
+	toc.WriteString(`</ol></nav>`)
+	return toc.String()
+}
+
+// levels returns the deepest heading level listed.
+func (t TableOfContents) levels() int {
+	if t.Levels < 1 || t.Levels > 6 {
+		return DefaultTOCLevels
+	}
+	return t.Levels
+}
+
+// insertTableOfContents generates the table of contents of the document loaded in the tab of ctx.
+// It prints the document once to find the pages of the headings, so that the next print has their page numbers.
+func (p *PDF) insertTableOfContents(ctx context.Context) error {
+	var entries []TOCEntry
+	err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf("%s(%d, %q)", collectHeadingsScript, p.TOC.levels(), tocIDPrefix), &entries))
+	if err != nil {
+		return fmt.Errorf("could not collect headings: %v", err)
+	}
+	// the placeholder leaves room for the page numbers, so that the pages do not move once they are filled
+	placeholders := make([]TOCEntry, len(entries))
+	for i, entry := range entries {
+		entry.Page = 888
+		placeholders[i] = entry
+	}
+	if err := p.setTableOfContents(ctx, placeholders); err != nil {
+		return err
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	settings := p.Settings
+	settings.TransferMode = ""
+	settings.PageRanges = ""
+	var draft []byte
+	err = chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) (err error) {
+		draft, _, err = settings.Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return fmt.Errorf("could not print the table of contents: %v", err)
+	}
+	ids := make([]string, len(entries))
+	for i, entry := range entries {
+		ids[i] = entry.ID
+	}
+	pages, err := destinationPages(draft, ids)
+	if err != nil {
+		return err
+	}
+	for i := range entries {
+		entries[i].Page = pages[entries[i].ID]
+	}
+	return p.setTableOfContents(ctx, entries)
+}
+
+// setTableOfContents inserts the table of contents into the document, or replaces it.
+func (p *PDF) setTableOfContents(ctx context.Context, entries []TOCEntry) error {
+	toc, _ := json.Marshal(p.TOC.tocHTML(entries))
+	var ok bool
+	if err := chromedp.Run(ctx, chromedp.Evaluate(fmt.Sprintf("%s(%s)", insertTOCScript, toc), &ok)); err != nil {
+		return fmt.Errorf("could not insert the table of contents: %v", err)
+	}
+	return nil
+}
+
+// destinationPages returns the pages of the named destinations of a PDF, by name.
+// Chrome names the destinations of the internal links after the id of their target.
+// The names that are not found are left out.
+func destinationPages(content []byte, names []string) (map[string]int, error) {
+	ctx, err := api.ReadContext(bytes.NewReader(content), pdfConfig())
+	if err != nil {
+		return nil, fmt.Errorf("could not read the pages of the headings: %v", err)
+	}
+	catalog, err := ctx.Catalog()
+	if err != nil {
+		return nil, fmt.Errorf("could not read the pages of the headings: %v", err)
+	}
+	dests := map[string]types.Object{}
+	// the destinations are either in the Dests name tree, or in the Dests dictionary of older PDFs
+	if namesDict, err := ctx.DereferenceDict(catalog["Names"]); err == nil && namesDict != nil {
+		readNameTree(ctx, namesDict["Dests"], dests, 0)
+	}
+	if destsDict, err := ctx.DereferenceDict(catalog["Dests"]); err == nil {
+		for name, dest := range destsDict {
+			dests[name] = dest
+		}
+	}
+
+	pages := map[string]int{}
+	for _, name := range names {
+		dest, err := ctx.Dereference(dests[name])
+		if d, ok := dest.(types.Dict); ok && err == nil {
+			dest, err = ctx.Dereference(d["D"])
+		}
+		array, ok := dest.(types.Array)
+		if err != nil || !ok || len(array) == 0 {
+			continue
+		}
+		ref, ok := array[0].(types.IndirectRef)
+		if !ok {
+			continue
+		}
+		if page, err := ctx.PageNumber(ref.ObjectNumber.Value()); err == nil && page > 0 {
+			pages[name] = page
+		}
+	}
+	return pages, nil
+}
+
+// maxNameTreeDepth bounds the depth of the name trees read by readNameTree, against cycles.
+const maxNameTreeDepth = 32
+
+// readNameTree adds the values of the name tree node to values, by name.
+func readNameTree(ctx *model.Context, node types.Object, values map[string]types.Object, depth int) {
+	d, err := ctx.DereferenceDict(node)
+	if err != nil || d == nil || depth > maxNameTreeDepth {
+		return
+	}
+	if names, err := ctx.DereferenceArray(d["Names"]); err == nil {
+		for i := 0; i+1 < len(names); i += 2 {
+			key, err := ctx.Dereference(names[i])
+			if err != nil {
+				continue
+			}
+			var name string
+			switch key := key.(type) {
+			case types.StringLiteral:
+				name, err = types.StringLiteralToString(key)
+			case types.HexLiteral:
+				name, err = types.HexLiteralToString(key)
+			default:
+				continue
+			}
+			if err == nil {
+				values[name] = names[i+1]
+			}
+		}
+	}
+	if kids, err := ctx.DereferenceArray(d["Kids"]); err == nil {
+		for _, kid := range kids {
+			readNameTree(ctx, kid, values, depth+1)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+﻿package lazypress
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestShouldWriteTheTableOfContentsEntries(t *testing.T) {
+	toc := TableOfContents{Title: "Summary & index", Template: `<li class="l{{level}}"><a href="#{{id}}">{{heading}} p. {{page}}</a></li>`}
+	got := toc.tocHTML([]TOCEntry{
+		{Level: 1, Heading: "Terms & conditions", ID: "terms", Page: 2},
+		{Level: 2, Heading: "Fees", ID: "lazypress-toc-2"},
+	})
+	for _, want := range []string{
+		`<div class="lazypress-toc-title">Summary &amp; index</div>`,
+		`<li class="l1"><a href="#terms">Terms &amp; conditions p. 2</a></li>`,
+		`<li class="l2"><a href="#lazypress-toc-2">Fees p. </a></li>`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected the table of contents to contain %s, got %s", want, got)
+		}
+	}
+
+	got = TableOfContents{}.tocHTML([]TOCEntry{{Level: 3, Heading: "Fees", ID: "fees", Page: 12}})
+	if !strings.Contains(got, DefaultTOCTitle) || !strings.Contains(got, `lazypress-toc-level-3`) || !strings.Contains(got, `<span class="lazypress-toc-page">12</span>`) {
+		t.Errorf("Expected the default title and template, got %s", got)
+	}
+}
+
+func TestShouldFindThePagesOfNamedDestinations(t *testing.T) {
+	content := newTestPDFWithCatalog("/Names << /Dests << /Names [(fees) [6 0 R /XYZ 0 792 0] (intro) [4 0 R /XYZ 0 792 0] (terms) [8 0 R /XYZ 0 400 0]] >> >> ",
+		"one", "two", "three")
+	pages, err := destinationPages(content, []string{"intro", "terms", "fees", "missing"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 || pages["intro"] != 1 || pages["fees"] != 2 || pages["terms"] != 3 {
+		t.Errorf("Expected the pages of the destinations, got %v", pages)
+	}
+
+	pages, err = destinationPages(newTestPDF("one"), []string{"intro"})
+	if err != nil || len(pages) != 0 {
+		t.Errorf("Expected no pages without destinations, got %v, %v", pages, err)
+	}
+}
+
+func TestShouldLoadTheTableOfContentsSettings(t *testing.T) {
+	var p PDF
+	params := map[string]string{"toc": "true", "tocTitle": "Index", "tocLevels": "2", "tocTemplate": "<li>{{heading}} {{var.client}}</li>", "var.client": "ACME"}
+	if err := p.LoadSettings(params, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	p.ExpandTemplates(nil)
+	if !p.TOC.Enabled || p.TOC.Title != "Index" || p.TOC.levels() != 2 || p.TOC.Template != "<li>{{heading}} ACME</li>" {
+		t.Errorf("Expected the table of contents settings, got %+v", p.TOC)
+	}
+	if (TableOfContents{}).levels() != DefaultTOCLevels {
+		t.Errorf("Expected the default levels to be %d", DefaultTOCLevels)
+	}
+
+	err := validateParams(map[string]string{"toc": "yes", "tocLevels": "7"})
+	if errs := paramErrors(err); len(errs) != 2 || errs[0].Name != "toc" || errs[1].Name != "tocLevels" {
+		t.Errorf("Expected toc and tocLevels to be rejected, got %v", err)
+	}
+}
+
+func TestShouldReadTheTableOfContentsTemplateFromMultipartRequests(t *testing.T) {
+	req, err := readConvertRequest(newMultipartRequest(t, "/convert", map[string]string{
+		"html": "<html><body><h1>Hello World</h1></body></html>",
+		"toc":  `<li><a href="#{{id}}">{{heading}}</a> {{page}}</li>`,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p PDF
+	if err := newServer(DefaultConfig()).applyHeaderFooter(&p, map[string]string{}, req); err != nil {
+		t.Fatal(err)
+	}
+	if p.TOC.Template != `<li><a href="#{{id}}">{{heading}}</a> {{page}}</li>` || p.Settings.DisplayHeaderFooter {
+		t.Errorf("Expected the template of the table of contents only, got %+v and %+v", p.TOC, p.Settings)
+	}
+}
+
+func TestShouldPrintTheTableOfContentsWithThePagesOfTheHeadings(t *testing.T) {
+	if _, err := (&Server{}).resolveChromePath(); err != nil {
+		t.Skip("chrome is not available:", err)
+	}
+	p := PDF{TOC: TableOfContents{Enabled: true}}
+	html := []byte(`<html><body><h1 id="intro" style="break-after: page">Introduction</h1><h1 id="terms">Terms</h1></body></html>`)
+	if err := p.Render(context.Background(), html); err != nil {
+		t.Fatal(err)
+	}
+	if pages := countPages(p.Content); pages != 3 {
+		t.Errorf("Expected the table of contents on its own page before the 2 pages of the document, got %d pages", pages)
+	}
+	pages, err := destinationPages(p.Content, []string{"intro", "terms"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages["intro"] != 2 || pages["terms"] != 3 {
+		t.Errorf("Expected the entries to link to the headings on pages 2 and 3, got %v", pages)
+	}
+}
@@ -0,0 +1,70 @@
+﻿package lazypress
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/alexferrari88/lazypress"
+
+// tracer returns the tracer used for the lazypress spans.
+// It is looked up every time so that the global tracer provider can be set at any moment.
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// InitTracing configures OpenTelemetry to export the lazypress spans via OTLP over HTTP.
+// endpoint is the address of the collector, either as host:port or as a URL (e.g. http://collector:4318).
+// If it is empty, the standard OTEL_EXPORTER_OTLP_* environment variables are used.
+// It also makes the server accept W3C trace context from incoming requests.
+// The returned function flushes the pending spans and stops the exporter.
+func InitTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	var opts []otlptracehttp.Option
+	switch {
+	case strings.Contains(endpoint, "://"):
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	case endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.ServiceName("lazypress"),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startSpan starts a span as a child of the one carried by ctx.
+func startSpan(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan records err, if any, on the span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,93 @@
+﻿package lazypress
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previousProvider := otel.GetTracerProvider()
+	previousPropagator := otel.GetTextMapPropagator()
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		provider.Shutdown(context.Background())
+		otel.SetTracerProvider(previousProvider)
+		otel.SetTextMapPropagator(previousPropagator)
+	})
+	return exporter
+}
+
+func findSpan(spans tracetest.SpanStubs, name string) *tracetest.SpanStub {
+	for i := range spans {
+		if spans[i].Name == name {
+			return &spans[i]
+		}
+	}
+	return nil
+}
+
+func TestShouldContinueIncomingTrace(t *testing.T) {
+	exporter := recordSpans(t)
+	html := `<html><body>Hello World</body></html>`
+	req, err := http.NewRequest("POST", "/convert", strings.NewReader(html))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Length", fmt.Sprint((len(html))))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+	NewServer(0, "").handleConvert(w, req)
+
+	spans := exporter.GetSpans()
+	convert := findSpan(spans, "lazypress.convert")
+	if convert == nil {
+		t.Fatal("Expected a lazypress.convert span")
+	}
+	if convert.SpanContext.TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Errorf("Expected the incoming trace to be continued, got trace %s", convert.SpanContext.TraceID())
+	}
+	if convert.Parent.SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Expected the incoming span to be the parent, got %s", convert.Parent.SpanID())
+	}
+	validate := findSpan(spans, "lazypress.validate")
+	if validate == nil {
+		t.Fatal("Expected a lazypress.validate span")
+	}
+	if validate.Parent.SpanID() != convert.SpanContext.SpanID() {
+		t.Error("Expected lazypress.validate to be a child of lazypress.convert")
+	}
+	if len(validate.Events) == 0 {
+		t.Error("Expected the validation error to be recorded")
+	}
+}
+
+func TestShouldTraceExport(t *testing.T) {
+	exporter := recordSpans(t)
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "parent")
+	p := PDF{Exporter: &mockWriter{}}
+	if err := p.ExportContext(ctx); err != nil {
+		t.Error(err)
+	}
+	parent.End()
+	export := findSpan(exporter.GetSpans(), "lazypress.export")
+	if export == nil {
+		t.Fatal("Expected a lazypress.export span")
+	}
+	if export.Parent.SpanID() != trace.SpanContextFromContext(ctx).SpanID() {
+		t.Error("Expected lazypress.export to be a child of the span in the context")
+	}
+}
@@ -0,0 +1,65 @@
+﻿package lazypress
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// unitsPerInch maps the supported length units to how many of them make an inch.
+var unitsPerInch = map[string]float64{
+	"in": 1,
+	"cm": 2.54,
+	"mm": 25.4,
+	"px": 96,
+	"pt": 72,
+}
+
+// ParseLength parses a length such as "1cm", "20mm", "0.5in", "96px" or "12pt" and returns it in inches,
+// which is the unit Chrome expects for paper sizes and margins.
+// A number without unit is taken as inches.
+func ParseLength(s string) (float64, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if s == "" {
+		return 0, fmt.Errorf("empty length")
+	}
+	number, unit := s, "in"
+	if len(s) > 2 {
+		if _, ok := unitsPerInch[s[len(s)-2:]]; ok {
+			number, unit = strings.TrimSpace(s[:len(s)-2]), s[len(s)-2:]
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid length %q: use a number followed by one of in, cm, mm, px, pt", s)
+	}
+	// ParseFloat accepts NaN and Inf, which pass every range check
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return 0, fmt.Errorf("invalid length %q: it is not a finite number", s)
+	}
+	return value / unitsPerInch[unit], nil
+}
+
+// ParseMargins parses margins written like the CSS margin shorthand, e.g. "1cm", "1cm 2cm", "1cm 2cm 3cm" or "1cm 2cm 3cm 4cm".
+// It returns the top, right, bottom and left margins in inches.
+func ParseMargins(s string) (top, right, bottom, left float64, err error) {
+	fields := strings.Fields(s)
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		if values[i], err = ParseLength(field); err != nil {
+			return 0, 0, 0, 0, err
+		}
+	}
+	switch len(values) {
+	case 1:
+		return values[0], values[0], values[0], values[0], nil
+	case 2:
+		return values[0], values[1], values[0], values[1], nil
+	case 3:
+		return values[0], values[1], values[2], values[1], nil
+	case 4:
+		return values[0], values[1], values[2], values[3], nil
+	}
+	return 0, 0, 0, 0, fmt.Errorf("invalid margins %q: expected from 1 to 4 lengths", s)
+}
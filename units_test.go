@@ -0,0 +1,56 @@
+﻿package lazypress
+
+import (
+	"math"
+	"testing"
+)
+
+func TestShouldParseLengthsWithUnits(t *testing.T) {
+	lengths := map[string]float64{
+		"1":      1,
+		"0.5in":  0.5,
+		"2.54cm": 1,
+		"25.4mm": 1,
+		"96px":   1,
+		"36pt":   0.5,
+		" 1 CM ": 1 / 2.54,
+	}
+	for s, expected := range lengths {
+		got, err := ParseLength(s)
+		if err != nil {
+			t.Errorf("Expected no error when parsing %q, got %v", s, err)
+		}
+		if math.Abs(got-expected) > 1e-9 {
+			t.Errorf("Expected %q to be %v inches, got %v", s, expected, got)
+		}
+	}
+}
+
+func TestShouldNotParseInvalidLengths(t *testing.T) {
+	for _, s := range []string{"", "cm", "1km", "one inch", "NaN", "inf", "-Infinitycm", "1e400"} {
+		if _, err := ParseLength(s); err == nil {
+			t.Errorf("Expected an error when parsing %q", s)
+		}
+	}
+}
+
+func TestShouldParseMarginsShorthand(t *testing.T) {
+	margins := map[string][4]float64{
+		"1in":             {1, 1, 1, 1},
+		"1in 2in":         {1, 2, 1, 2},
+		"1in 2in 3in":     {1, 2, 3, 2},
+		"1in 2in 3in 4in": {1, 2, 3, 4},
+	}
+	for s, expected := range margins {
+		top, right, bottom, left, err := ParseMargins(s)
+		if err != nil {
+			t.Errorf("Expected no error when parsing %q, got %v", s, err)
+		}
+		if got := [4]float64{top, right, bottom, left}; got != expected {
+			t.Errorf("Expected %q to be %v, got %v", s, expected, got)
+		}
+	}
+	if _, _, _, _, err := ParseMargins("1in 2in 3in 4in 5in"); err == nil {
+		t.Error("Expected an error when passing more than 4 margins")
+	}
+}